@@ -0,0 +1,357 @@
+package atuinfzf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const _atuinDelim = "\t:::\t"
+
+// SearchParams are the parameters for an atuin search.
+type SearchParams struct {
+	Query          string
+	Limit          int
+	FilterMode     string
+	AdditionalArgs []string
+}
+
+// Entry is a history entry from atuin, with fields formatted by atuin.
+type Entry struct {
+	Time         string
+	RelativeTime string
+	Duration     string
+	Exit         string
+	Directory    string
+	Command      string
+
+	// Session is atuin's session ID, only set with Options.GroupBySession.
+	Session string
+
+	// Error is set if the history couldn't be read, with no other fields set.
+	Error error
+}
+
+// Search runs an atuin search, returning the entries in the order atuin prints them.
+// Errors reading the results are returned as an Entry with Error set.
+func Search(ctx context.Context, opts Options, p SearchParams) (iter.Seq[Entry], error) {
+	fields := []string{
+		"{time}",
+		"{relativetime}",
+		"{duration}",
+		"{exit}",
+		"{directory}",
+	}
+	if opts.GroupBySession {
+		// Only requested when needed, as older versions of atuin don't support it.
+		fields = append(fields, "{session}")
+	}
+	fields = append(fields, "{command}") // intentionally last so command can contain the delimiter.
+	format := strings.Join(fields, _atuinDelim)
+
+	args := []string{
+		"search",
+		"--limit", strconv.Itoa(p.Limit),
+		"--format", format,
+		"--print0",
+	}
+	if p.FilterMode != "" {
+		args = append(args,
+			"--filter-mode", p.FilterMode)
+	}
+	args = append(args, p.AdditionalArgs...)
+	args = append(args, opts.AtuinArgs...)
+	args = append(args, p.Query)
+
+	proc, err := startAtuin(ctx, opts, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(yield func(Entry) bool) {
+		for attempt := 1; ; attempt++ {
+			n, more := proc.scan(opts, yield)
+			if !more {
+				proc.stdout.Close()
+				proc.cmd.Wait()
+				return
+			}
+
+			err := proc.cmd.Wait()
+			if err != nil && n == 0 && attempt <= opts.Retries && isDatabaseLocked(proc.stderr.Bytes()) {
+				// Concurrent access to atuin's SQLite database can fail transiently, so retry silently.
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Duration(attempt) * _retryBackoff):
+				}
+
+				if proc, err = startAtuin(ctx, opts, args); err != nil {
+					yield(Entry{Error: err})
+					return
+				}
+				continue
+			}
+
+			if err != nil && ctx.Err() == nil {
+				// Searches canceled by ctx are killed, which callers already know about.
+				yield(Entry{Error: fmt.Errorf("atuin search: %w: %s", err, bytes.TrimSpace(proc.stderr.Bytes()))})
+				return
+			}
+			os.Stderr.Write(proc.stderr.Bytes())
+			return
+		}
+	}, nil
+}
+
+// _retryBackoff is the delay before the first retry of a failed atuin search,
+// increasing linearly with each attempt.
+const _retryBackoff = 100 * time.Millisecond
+
+func isDatabaseLocked(stderr []byte) bool {
+	return bytes.Contains(stderr, []byte("database is locked"))
+}
+
+// atuinProc is a running atuin search.
+type atuinProc struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+}
+
+func startAtuin(ctx context.Context, opts Options, args []string) (*atuinProc, error) {
+	var proc atuinProc
+	proc.cmd = exec.CommandContext(ctx, opts.AtuinBin, args...)
+	proc.cmd.Stderr = &proc.stderr
+
+	stdout, err := proc.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	proc.stdout = stdout
+
+	if err := proc.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &proc, nil
+}
+
+// scan parses and yields the search results, returning the number of entries yielded,
+// and false if the consumer stopped early.
+func (p *atuinProc) scan(opts Options, yield func(Entry) bool) (n int, more bool) {
+	var skipped int
+	scanner := bufio.NewScanner(p.stdout)
+	// The initial capacity also limits the token size, so it must not exceed the max.
+	scanner.Buffer(make([]byte, 0, min(bufio.MaxScanTokenSize, opts.MaxCommandSize)), opts.MaxCommandSize)
+	scanner.Split(scanNullMax(opts.MaxCommandSize, &skipped))
+	defer func() {
+		if skipped > 0 {
+			log.Printf("skipped %d commands longer than --max-command-size=%d", skipped, opts.MaxCommandSize)
+		}
+	}()
+	for scanner.Scan() {
+		// atuin may print a trailing newline after the last entry.
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		numFields := 6
+		if opts.GroupBySession {
+			numFields++
+		}
+		parts := strings.SplitN(scanner.Text(), _atuinDelim, numFields)
+		if len(parts) < numFields {
+			yield(Entry{
+				Error: fmt.Errorf("text %q doesn't have expected %d delimiters", scanner.Text(), numFields-1),
+			})
+			return n, false
+		}
+
+		e := Entry{
+			Time:         parts[0],
+			RelativeTime: parts[1],
+			Duration:     parts[2],
+			Exit:         parts[3],
+			Directory:    parts[4],
+			Command:      parts[numFields-1],
+		}
+		if opts.GroupBySession {
+			e.Session = parts[5]
+		}
+
+		n++
+		if !yield(e) {
+			return n, false
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		yield(Entry{Error: err})
+		return n, false
+	}
+	return n, true
+}
+
+// scanNullMax returns a split function like scanNull that skips, rather than fails on,
+// entries longer than maxSize, counting them in skipped.
+func scanNullMax(maxSize int, skipped *int) bufio.SplitFunc {
+	var skipping bool
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if skipping {
+			if i := bytes.IndexByte(data, byte(0)); i >= 0 {
+				skipping = false
+				return i + 1, nil, nil
+			}
+			return len(data), nil, nil
+		}
+
+		advance, token, err = scanNull(data, atEOF)
+		if advance == 0 && token == nil && err == nil && len(data) >= maxSize {
+			// The buffer is full without a terminator, skip until the next one.
+			*skipped++
+			skipping = true
+			return len(data), nil, nil
+		}
+		return advance, token, err
+	}
+}
+
+func scanNull(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexByte(data, byte(0)); i >= 0 {
+		// terminated line.
+		return i + 1, data[0:i], nil
+	}
+	// If we're at EOF, we have a final, non-terminated line. Return it.
+	if atEOF {
+		return len(data), data, nil
+	}
+	// Request more data.
+	return 0, nil, nil
+}
+
+// Searcher runs history searches, allowing atuin to be replaced.
+// The preview may call Search concurrently.
+type Searcher interface {
+	Search(ctx context.Context, p SearchParams) (iter.Seq[Entry], error)
+}
+
+// AtuinSearcher returns a Searcher that searches using atuin.
+func AtuinSearcher(opts Options) Searcher {
+	return atuinSearcher{opts}
+}
+
+type atuinSearcher struct {
+	opts Options
+}
+
+func (s atuinSearcher) Search(ctx context.Context, p SearchParams) (iter.Seq[Entry], error) {
+	return Search(ctx, s.opts, p)
+}
+
+// CommandHistory returns up to limit recent runs of exactly command.
+func CommandHistory(ctx context.Context, opts Options, command string, limit int) ([]Entry, error) {
+	return commandHistory(ctx, AtuinSearcher(opts), command, limit)
+}
+
+func commandHistory(ctx context.Context, s Searcher, command string, limit int) ([]Entry, error) {
+	results, err := s.Search(ctx, SearchParams{
+		Query:          command,
+		Limit:          limit,
+		AdditionalArgs: []string{"--search-mode", "prefix"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []Entry
+	for r := range results {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		if r.Command == command {
+			runs = append(runs, r)
+		}
+	}
+	return runs, ctx.Err()
+}
+
+// Relative returns how long before now the entry was run, preferring atuin's
+// {relativetime}, and falling back to computing it from {time} if it's missing.
+func (e Entry) Relative(now time.Time) string {
+	if e.RelativeTime != "" {
+		return e.RelativeTime
+	}
+
+	t, err := ParseTime(e.Time)
+	if err != nil {
+		return ""
+	}
+	return formatRelative(now.Sub(t))
+}
+
+// formatRelative formats d using its largest unit, like atuin's {relativetime}.
+func formatRelative(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
+// _atuinTimeLayout is the layout of atuin's {time} format token.
+const _atuinTimeLayout = "2006-01-02 15:04:05"
+
+// ParseTime parses atuin's {time} format token, which is in local time.
+func ParseTime(s string) (time.Time, error) {
+	return time.ParseInLocation(_atuinTimeLayout, s, time.Local)
+}
+
+// ParseDuration parses atuin's {duration} format token, such as "1m5s" or "2d3h".
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.ReplaceAll(s, " ", "")
+
+	// time.ParseDuration doesn't support units larger than hours.
+	var d time.Duration
+	for _, unit := range []struct {
+		suffix string
+		dur    time.Duration
+	}{
+		{"y", 365 * 24 * time.Hour},
+		{"d", 24 * time.Hour},
+	} {
+		if n, rest, ok := strings.Cut(s, unit.suffix); ok {
+			v, err := strconv.Atoi(n)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q: %w", s, err)
+			}
+			d += time.Duration(v) * unit.dur
+			s = rest
+		}
+	}
+	if s == "" {
+		return d, nil
+	}
+
+	rest, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return d + rest, nil
+}
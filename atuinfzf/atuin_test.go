@@ -0,0 +1,133 @@
+package atuinfzf
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// atuinRecord returns an entry in the format printed by atuin for Search.
+func atuinRecord(exit, dir, command string) string {
+	return strings.Join([]string{"2025-01-02 10:00:00", "5m", "1s", exit, dir, command}, _atuinDelim) + "\x00"
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "empty",
+			output: "",
+		},
+		{
+			name:   "entries",
+			output: atuinRecord("0", "/srv", "ls") + atuinRecord("1", "/tmp", "false"),
+			want:   []string{"ls", "false"},
+		},
+		{
+			name:   "trailing newline",
+			output: atuinRecord("0", "/srv", "ls") + "\n",
+			want:   []string{"ls"},
+		},
+		{
+			name:   "trailing blank record",
+			output: atuinRecord("0", "/srv", "ls") + " \n\x00",
+			want:   []string{"ls"},
+		},
+		{
+			name:   "blank records between entries",
+			output: atuinRecord("0", "/srv", "ls") + "\x00\n\x00" + atuinRecord("0", "/srv", "pwd"),
+			want:   []string{"ls", "pwd"},
+		},
+		{
+			name:   "delimiter in command",
+			output: atuinRecord("0", "/srv", "echo a"+_atuinDelim+"b"),
+			want:   []string{"echo a" + _atuinDelim + "b"},
+		},
+		{
+			name:    "missing fields",
+			output:  atuinRecord("0", "/srv", "ls") + "ls\x00",
+			want:    []string{"ls"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proc := &atuinProc{stdout: io.NopCloser(strings.NewReader(tt.output))}
+
+			var (
+				got    []string
+				gotErr error
+			)
+			proc.scan(Options{MaxCommandSize: 1 << 20}, func(e Entry) bool {
+				if e.Error != nil {
+					gotErr = e.Error
+					return false
+				}
+				got = append(got, e.Command)
+				return true
+			})
+
+			if (gotErr != nil) != tt.wantErr {
+				t.Errorf("error %v, want error: %v", gotErr, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("commands %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanMaxCommandSize(t *testing.T) {
+	// maxSize fits a record, including its terminator, with a 10 byte command.
+	maxSize := len(atuinRecord("0", "/srv", "")) + 10
+
+	tests := []struct {
+		name    string
+		command string
+		want    []string
+	}{
+		{
+			name:    "below the limit",
+			command: strings.Repeat("a", 9),
+			want:    []string{strings.Repeat("a", 9), "ls"},
+		},
+		{
+			name:    "at the limit",
+			command: strings.Repeat("a", 10),
+			want:    []string{strings.Repeat("a", 10), "ls"},
+		},
+		{
+			name:    "above the limit",
+			command: strings.Repeat("a", 11),
+			want:    []string{"ls"},
+		},
+		{
+			name:    "far above the limit",
+			command: strings.Repeat("a", 10000),
+			want:    []string{"ls"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := atuinRecord("0", "/srv", tt.command) + atuinRecord("0", "/srv", "ls")
+			proc := &atuinProc{stdout: io.NopCloser(strings.NewReader(output))}
+
+			var got []string
+			proc.scan(Options{MaxCommandSize: maxSize}, func(e Entry) bool {
+				if e.Error != nil {
+					t.Fatalf("scan error: %v", e.Error)
+				}
+				got = append(got, e.Command)
+				return true
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("commands %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
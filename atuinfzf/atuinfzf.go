@@ -0,0 +1,261 @@
+// Package atuinfzf loads shell history from atuin, adapts it into rows
+// for fzf, and renders previews of history entries.
+package atuinfzf
+
+import (
+	"context"
+	"iter"
+)
+
+// Options configure how history is loaded and displayed.
+type Options struct {
+	// AtuinBin is the atuin binary name or path.
+	AtuinBin string
+
+	// Limit is the maximum number of history entries to load.
+	Limit int
+
+	// Retries is the number of times to retry an atuin search that fails
+	// because atuin's database is locked.
+	Retries int
+
+	// MaxCommandSize is the maximum size in bytes of a history entry.
+	// Longer entries are skipped.
+	MaxCommandSize int
+
+	// Timeline shows a sparkline of when the command was run in the preview.
+	Timeline bool
+
+	// UsualDir shows the directory the command is most often run in, in the preview.
+	UsualDir bool
+
+	// AvgDuration shows the average duration of the command's runs in the preview.
+	AvgDuration bool
+
+	// LastSuccess shows when and where a failed command last succeeded in the preview.
+	LastSuccess bool
+
+	// DirSegments elides the middle of directories with more path segments
+	// than this in the preview. 0 disables eliding.
+	DirSegments int
+
+	// PreviewMaxBytes truncates commands in the preview to this many bytes.
+	// 0 disables truncation.
+	PreviewMaxBytes int
+
+	// PlainPreview only shows the command and the details of the entry in the preview,
+	// without searching atuin for the command's history and similar commands,
+	// or starting the shell to look up the command's type.
+	PlainPreview bool
+
+	// ASCII uses ASCII in place of box-drawing characters and symbols,
+	// for terminals or locales without Unicode support.
+	ASCII bool
+
+	// SimilarWidth truncates similar commands in the preview to a single line
+	// of this many columns. 0 shows the full commands, wrapped by fzf.
+	SimilarWidth int
+
+	// NoCurrentDirMarker omits the marker on entries run in the current directory.
+	NoCurrentDirMarker bool
+
+	// ResolveSymlinks treats directories that resolve to the same directory through
+	// symlinks as the same, for the current directory marker, SmartDir, ByDir and Dir.
+	ResolveSymlinks bool
+
+	// NoExitStatus omits the exit status of failed commands from FieldExitStatus.
+	// The exit code is still available in FieldExit.
+	NoExitStatus bool
+
+	// StatusBadge adds a fixed-width exit status badge to rows.
+	StatusBadge bool
+
+	// CommandsOnly lists each program run once, in place of the commands,
+	// with the number of times it was run.
+	CommandsOnly bool
+
+	// Program only lists commands that run this program, as returned by CommandProgram.
+	Program string
+
+	// ByDir lists each directory commands were run in once, in place of the commands,
+	// with the number of commands run there.
+	ByDir bool
+
+	// Dir only lists commands run in this directory.
+	Dir string
+
+	// ExcludeCommand omits entries running this command, ignoring surrounding whitespace,
+	// such as the command line the history was opened from.
+	ExcludeCommand string
+
+	// SmartDir lists entries run in the current directory after all other entries,
+	// so they're closest to the prompt.
+	SmartDir bool
+
+	// GroupByTime writes separator rows between entries run at different times,
+	// labeled such as "Today" or "Last week". Separator rows have no command.
+	GroupByTime bool
+
+	// GroupBySession requests each entry's session from atuin, and writes
+	// separator rows between entries from different sessions, labeled with the session.
+	// It requires a version of atuin that supports {session} in the search format.
+	GroupBySession bool
+
+	// NewestFirst is set if rows are displayed newest first. As fzf reverses
+	// the rows, separator labels are then written after their entries.
+	NewestFirst bool
+
+	// HideFailed only lists commands that exited successfully.
+	HideFailed bool
+
+	// FavoritesOnly only lists favorite commands.
+	FavoritesOnly bool
+
+	// Dedup lists each command once, at its most recent run,
+	// with the number of times it was run.
+	Dedup bool
+
+	// CollapseRuns lists consecutive runs of the same command once, at the most
+	// recent run, with the number of times it was run. Unlike Dedup, other runs
+	// of the command are listed separately.
+	CollapseRuns bool
+
+	// FilterMode is atuin's filter mode for the history, such as global or host.
+	// If empty, atuin's default is used.
+	FilterMode string
+
+	// SearchMode is atuin's search mode for Query, such as prefix or fuzzy.
+	// If empty, atuin's default is used.
+	SearchMode string
+
+	// Query filters the history using atuin's search.
+	Query string
+
+	// Cwd only lists commands run in this directory, using atuin's search.
+	Cwd string
+
+	// Hint is written as a separator row after the entries, closest to the prompt,
+	// such as to note that a query's directory alias wasn't found.
+	Hint string
+
+	// AtuinArgs are extra arguments for atuin's search. atuin rejects arguments
+	// that are already set, such as --limit or --format.
+	AtuinArgs []string
+
+	// IgnorePrefixes splits leading environment variable assignments and sudo
+	// or doas from the displayed command into FieldPrefix, so they can be excluded from matching.
+	IgnorePrefixes bool
+
+	// After only lists entries run after this time, in any format atuin's --after accepts.
+	After string
+
+	// DebugRank annotates rows with their rank in the list without a query,
+	// and with Dedup, how many times they were run, to debug fzf's ordering.
+	// Rows are written once they're all loaded.
+	DebugRank bool
+
+	// MetaColumn pads displayed commands narrower than this many columns,
+	// so the markers displayed after them are aligned. If 0, commands aren't padded.
+	MetaColumn int
+
+	// Normalize trims and collapses whitespace in commands for display and
+	// deduplication. The original command is still selected.
+	Normalize bool
+}
+
+// History returns the history to list, filtered by FilterMode, with the
+// current session's entries last, so they're closest to the prompt.
+func History(ctx context.Context, opts Options) (iter.Seq[Entry], error) {
+	var addArgs []string
+	if opts.After != "" {
+		addArgs = append(addArgs, "--after", opts.After)
+	}
+	if opts.SearchMode != "" {
+		addArgs = append(addArgs, "--search-mode", opts.SearchMode)
+	}
+	if opts.HideFailed {
+		addArgs = append(addArgs, "--exit", "0")
+	}
+	if opts.Cwd != "" {
+		addArgs = append(addArgs, "--cwd", opts.Cwd)
+	}
+
+	globalResults, err := Search(ctx, opts, SearchParams{
+		Query:          opts.Query,
+		Limit:          opts.Limit,
+		FilterMode:     opts.FilterMode,
+		AdditionalArgs: addArgs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sessionResults, err := Search(ctx, opts, SearchParams{
+		Query:          opts.Query,
+		Limit:          opts.Limit,
+		FilterMode:     "session",
+		AdditionalArgs: addArgs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeRight(globalResults, sessionResults, opts.Normalize), nil
+}
+
+// Stream is like History, but yields errors reading the history separately
+// from entries, rather than in Entry.Error. The history ends after an error.
+func Stream(ctx context.Context, opts Options) (iter.Seq2[Entry, error], error) {
+	entries, err := History(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(yield func(Entry, error) bool) {
+		for e := range entries {
+			if e.Error != nil {
+				yield(Entry{}, e.Error)
+				return
+			}
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+// mergeRight merges results sequences, preferring results on the right.
+// If normalize is set, entries are compared using their normalized commands.
+func mergeRight(res1, res2 iter.Seq[Entry], normalize bool) iter.Seq[Entry] {
+	key := func(e Entry) Entry {
+		if normalize {
+			e.Command = NormalizeCommand(e.Command)
+		}
+		return e
+	}
+
+	var res2Vals []Entry
+	seen := make(map[Entry]struct{})
+	for r := range res2 {
+		seen[key(r)] = struct{}{}
+		res2Vals = append(res2Vals, r)
+	}
+
+	return func(yield func(Entry) bool) {
+		for r := range res1 {
+			if _, ok := seen[key(r)]; ok {
+				continue
+			}
+
+			if !yield(r) {
+				return
+			}
+		}
+
+		for _, r := range res2Vals {
+			if !yield(r) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package atuinfzf
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// commandType returns how the user's shell resolves the CommandProgram of command,
+// one of "alias", "function", "builtin", "binary" or "missing".
+// It returns an empty string if the program or its type can't be determined.
+func commandType(ctx context.Context, command string) string {
+	program := CommandProgram(command)
+	if program == "" || isAssignment(program) {
+		// Assignments without a command don't run a program.
+		return ""
+	}
+
+	shell := os.Getenv("SHELL")
+	switch filepath.Base(shell) {
+	case "zsh":
+		// whence -w prints "<name>: <type>".
+		out := shellOutput(ctx, shell, `whence -w -- "$1"`, program)
+		_, typ, _ := strings.Cut(out, ": ")
+		switch typ {
+		case "alias", "function", "builtin":
+			return typ
+		case "reserved":
+			return "builtin"
+		case "command", "hashed":
+			return "binary"
+		case "none":
+			return "missing"
+		}
+		return ""
+	case "bash":
+		switch typ := shellOutput(ctx, shell, `type -t -- "$1"`, program); typ {
+		case "alias", "function", "builtin":
+			return typ
+		case "keyword":
+			return "builtin"
+		case "file":
+			return "binary"
+		case "":
+			if ctx.Err() != nil {
+				return ""
+			}
+			return "missing"
+		}
+		return ""
+	}
+
+	if os.Getenv("PATH") == "" {
+		// Without a PATH, such as in a sparse environment, programs can't be found.
+		return ""
+	}
+	if _, err := exec.LookPath(program); err != nil {
+		return "missing"
+	}
+	return "binary"
+}
+
+// shellOutput runs script in an interactive shell, so aliases and functions
+// from the user's rc files are defined, and returns the last line of output.
+func shellOutput(ctx context.Context, shell, script string, args ...string) string {
+	cmd := exec.CommandContext(ctx, shell, append([]string{"-ic", script, shell}, args...)...)
+	out, _ := cmd.Output() // the lookup reports failures via its output
+	out = bytes.TrimSpace(out)
+	if i := bytes.LastIndexByte(out, '\n'); i >= 0 {
+		out = out[i+1:]
+	}
+	return string(out)
+}
+
+// pipelineStages splits command into the stages of a pipeline, separated by
+// unquoted | or |&. It returns nil if command isn't a pipeline.
+func pipelineStages(command string) []string {
+	var (
+		stages []string
+		quote  byte
+		start  int
+	)
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\\':
+			i++
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '|':
+			if i+1 < len(command) && command[i+1] == '|' {
+				i++ // || runs the next command on failure, it's not a pipe.
+				continue
+			}
+			stages = append(stages, strings.TrimSpace(command[start:i]))
+			if i+1 < len(command) && command[i+1] == '&' {
+				i++
+			}
+			start = i + 1
+		}
+	}
+	if len(stages) == 0 {
+		return nil
+	}
+	return append(stages, strings.TrimSpace(command[start:]))
+}
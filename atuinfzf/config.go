@@ -0,0 +1,79 @@
+package atuinfzf
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AtuinConfig holds the settings from atuin's config that atuin-fzf inherits.
+type AtuinConfig struct {
+	FilterMode string
+	SearchMode string
+}
+
+// atuinConfigPath returns the path to atuin's config file, respecting ATUIN_CONFIG_DIR.
+func atuinConfigPath() (string, error) {
+	if dir := os.Getenv("ATUIN_CONFIG_DIR"); dir != "" {
+		return filepath.Join(dir, "config.toml"), nil
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "atuin", "config.toml"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "atuin", "config.toml"), nil
+}
+
+// LoadAtuinConfig reads the settings atuin-fzf inherits from atuin's config.
+// Only top-level string settings are supported, which covers the settings used.
+func LoadAtuinConfig() (AtuinConfig, error) {
+	var cfg AtuinConfig
+
+	p, err := atuinConfigPath()
+	if err != nil {
+		return cfg, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			// Settings after the first table aren't top-level.
+			break
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		// Only double-quoted strings are supported, followed by an optional comment.
+		value = strings.TrimSpace(value)
+		value, err := strconv.QuotedPrefix(value)
+		if err != nil {
+			continue
+		}
+		value, err = strconv.Unquote(value)
+		if err != nil {
+			continue
+		}
+
+		switch strings.TrimSpace(key) {
+		case "filter_mode":
+			cfg.FilterMode = value
+		case "search_mode":
+			cfg.SearchMode = value
+		}
+	}
+	return cfg, scanner.Err()
+}
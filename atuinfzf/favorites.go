@@ -0,0 +1,127 @@
+package atuinfzf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// DataDir returns the directory for atuin-fzf's data, $XDG_DATA_HOME/atuin-fzf,
+// defaulting to ~/.local/share/atuin-fzf.
+func DataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "atuin-fzf"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "atuin-fzf"), nil
+}
+
+// Favorites is a set of favorite commands.
+type Favorites map[string]struct{}
+
+func favoritesPath() (string, error) {
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "favorites"), nil
+}
+
+// LoadFavorites loads the favorite commands, which is empty if none have been saved.
+func LoadFavorites() (Favorites, error) {
+	p, err := favoritesPath()
+	if err != nil {
+		return nil, err
+	}
+
+	var cmds []string
+	if err := readJSON(p, &cmds); err != nil {
+		return nil, err
+	}
+
+	favs := make(Favorites, len(cmds))
+	for _, cmd := range cmds {
+		favs[cmd] = struct{}{}
+	}
+	return favs, nil
+}
+
+// Save saves the favorite commands.
+func (f Favorites) Save() error {
+	p, err := favoritesPath()
+	if err != nil {
+		return err
+	}
+	return writeJSON(p, slices.Sorted(maps.Keys(f)))
+}
+
+// ToggleFavorite adds command to the favorites, or removes it if it's already a favorite.
+// It returns whether the command is now a favorite.
+func ToggleFavorite(command string) (bool, error) {
+	favs, err := LoadFavorites()
+	if err != nil {
+		return false, err
+	}
+
+	_, isFav := favs[command]
+	if isFav {
+		delete(favs, command)
+	} else {
+		favs[command] = struct{}{}
+	}
+	return !isFav, favs.Save()
+}
+
+// readJSON decodes the JSON file at path into v.
+// A missing file is not an error, and leaves v unmodified.
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parse %v: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSON atomically writes v as JSON to the file at path.
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
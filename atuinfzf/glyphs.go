@@ -0,0 +1,60 @@
+package atuinfzf
+
+import "github.com/prashantv/atuin-fzf/tcolor"
+
+// glyphs are the non-text characters used in rows and previews.
+type glyphs struct {
+	Rule     string
+	Ellipsis string
+	Dash     string
+	Favorite string
+	Success  string
+	Spark    []rune
+
+	// Pass and Fail mark statuses with themes that use StatusGlyphs.
+	Pass string
+	Fail string
+}
+
+var (
+	_unicodeGlyphs = glyphs{
+		Rule:     "────────────────────────",
+		Ellipsis: "…",
+		Dash:     "—",
+		Favorite: "★",
+		Success:  "●",
+		Spark:    []rune("▁▂▃▄▅▆▇█"),
+		Pass:     "✓",
+		Fail:     "✗",
+	}
+	_asciiGlyphs = glyphs{
+		Rule:     "------------------------",
+		Ellipsis: "...",
+		Dash:     "--",
+		Favorite: "*",
+		Success:  "ok",
+		Spark:    []rune("_.-:=+*#"),
+		Pass:     "+",
+		Fail:     "x",
+	}
+)
+
+// statusGlyph returns the glyph marking exitCode as a success or failure,
+// or an empty string if the current theme doesn't mark statuses with glyphs.
+func (g glyphs) statusGlyph(exitCode string) string {
+	switch {
+	case !tcolor.Current().StatusGlyphs:
+		return ""
+	case exitCode == "0":
+		return g.Pass
+	default:
+		return g.Fail
+	}
+}
+
+func (o Options) glyphs() glyphs {
+	if o.ASCII {
+		return _asciiGlyphs
+	}
+	return _unicodeGlyphs
+}
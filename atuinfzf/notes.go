@@ -0,0 +1,52 @@
+package atuinfzf
+
+import "path/filepath"
+
+// Notes are freeform notes attached to commands, keyed by the command.
+type Notes map[string]string
+
+func notesPath() (string, error) {
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "notes.json"), nil
+}
+
+// LoadNotes loads the notes, which is empty if none have been saved.
+func LoadNotes() (Notes, error) {
+	p, err := notesPath()
+	if err != nil {
+		return nil, err
+	}
+
+	notes := make(Notes)
+	if err := readJSON(p, &notes); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// Save saves the notes.
+func (n Notes) Save() error {
+	p, err := notesPath()
+	if err != nil {
+		return err
+	}
+	return writeJSON(p, n)
+}
+
+// SetNote sets the note for command, removing it if note is empty.
+func SetNote(command, note string) error {
+	notes, err := LoadNotes()
+	if err != nil {
+		return err
+	}
+
+	if note == "" {
+		delete(notes, command)
+	} else {
+		notes[command] = note
+	}
+	return notes.Save()
+}
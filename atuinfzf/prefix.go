@@ -0,0 +1,92 @@
+package atuinfzf
+
+import (
+	"strings"
+	"unicode"
+)
+
+// _privilegeCmds run the rest of the command as another user.
+var _privilegeCmds = map[string]bool{
+	"sudo": true,
+	"doas": true,
+}
+
+// _privilegeArgOpts are options of _privilegeCmds that take an argument.
+var _privilegeArgOpts = map[string]bool{
+	"-u": true, "-g": true, "-C": true, "-D": true,
+	"-h": true, "-p": true, "-r": true, "-t": true, "-U": true,
+}
+
+// SplitCommandPrefix splits command into a prefix of leading environment
+// variable assignments and sudo or doas (with their options), and the rest,
+// which is the command that's actually run. The prefix includes trailing whitespace.
+func SplitCommandPrefix(command string) (prefix, rest string) {
+	var (
+		i         int
+		privilege bool // whether a privilege command was seen, so options are skipped.
+	)
+	for {
+		start := skipSpace(command, i)
+		word, end := shellWord(command, start)
+		switch {
+		case word == "":
+			// There's no command after the prefix, so there's nothing to split.
+			return "", command
+		case isAssignment(word):
+		case _privilegeCmds[word]:
+			privilege = true
+		case privilege && strings.HasPrefix(word, "-"):
+			if _privilegeArgOpts[word] {
+				_, end = shellWord(command, skipSpace(command, end))
+			}
+		default:
+			return command[:start], command[start:]
+		}
+		i = end
+	}
+}
+
+// CommandProgram returns the program run by command, which is its first word
+// after any prefix split by SplitCommandPrefix.
+func CommandProgram(command string) string {
+	_, rest := SplitCommandPrefix(command)
+	word, _ := shellWord(rest, skipSpace(rest, 0))
+	return word
+}
+
+func skipSpace(s string, i int) int {
+	return len(s) - len(strings.TrimLeftFunc(s[i:], unicode.IsSpace))
+}
+
+// isAssignment returns whether word is an environment variable assignment, like FOO=bar.
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// shellWord returns the shell word starting at start, with quotes intact,
+// and the index after it. Words are ended by unquoted whitespace.
+func shellWord(s string, start int) (string, int) {
+	var quote rune
+	for i, r := range s[start:] {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case unicode.IsSpace(r):
+			return s[start : start+i], start + i
+		}
+	}
+	return s[start:], len(s)
+}
@@ -0,0 +1,455 @@
+package atuinfzf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prashantv/atuin-fzf/tcolor"
+)
+
+// _previewTimeout bounds the subprocesses run by the preview,
+// so a slow shell or atuin never hangs the UI.
+const _previewTimeout = 2 * time.Second
+
+const (
+	// _similarCount is the number of similar commands shown,
+	// both overall and run in the same directory.
+	_similarCount = 5
+
+	// _similarSearchLimit bounds the search for similar commands,
+	// which may miss commands run in the same directory if there are many similar commands.
+	_similarSearchLimit = 100
+
+	// _commandHistoryLimit bounds the runs of the command loaded for
+	// the timeline and last success.
+	_commandHistoryLimit = 1000
+)
+
+// RenderPreview writes a preview of the history entry e to w,
+// including details of the entry and similar commands from atuin.
+func RenderPreview(w io.Writer, e Entry, opts Options) error {
+	return RenderPreviewWith(context.Background(), w, e, opts, AtuinSearcher(opts))
+}
+
+// RenderPreviewWith is like RenderPreview, but uses s to find
+// the command's history and similar commands, and stops searching
+// once ctx is canceled.
+func RenderPreviewWith(ctx context.Context, w io.Writer, e Entry, opts Options, s Searcher) error {
+	ctx, cancel := context.WithTimeout(ctx, _previewTimeout)
+	defer cancel()
+
+	if opts.PlainPreview {
+		// Sections that require the command's history aren't shown.
+		opts.Timeline, opts.AvgDuration, opts.LastSuccess, opts.UsualDir = false, false, false, false
+	}
+
+	// The searches are started upfront, so they run concurrently with each other,
+	// and with the shell looking up the command's type.
+	showLastSuccess := opts.LastSuccess && e.Exit != "0"
+	var (
+		wg                  sync.WaitGroup
+		runs                []Entry
+		runsErr             error
+		recent, recentInDir []Entry
+		similarErr          error
+	)
+	if opts.Timeline || opts.AvgDuration || showLastSuccess || opts.UsualDir {
+		wg.Go(func() {
+			runs, runsErr = commandHistory(ctx, s, e.Command, _commandHistoryLimit)
+		})
+	}
+	if !opts.PlainPreview {
+		wg.Go(func() {
+			recent, recentInDir, similarErr = similarCommands(ctx, s, e)
+		})
+	}
+
+	g := opts.glyphs()
+	exitCol := tcolor.Success
+	if e.Exit != "0" {
+		exitCol = tcolor.Failure
+	}
+
+	fmt.Fprintln(w, tcolor.Bold("Command"))
+	fmt.Fprintln(w, g.Rule)
+	fmt.Fprintln(w, previewCommand(e.Command, opts.PreviewMaxBytes, g))
+	if !opts.PlainPreview {
+		switch typ := commandType(ctx, e.Command); typ {
+		case "":
+		case "missing":
+			fmt.Fprintf(w, "%-10s %s\n", "Type:", tcolor.Failure(typ))
+		default:
+			fmt.Fprintf(w, "%-10s %s\n", "Type:", typ)
+		}
+	}
+	fmt.Fprintln(w)
+	if stages := pipelineStages(e.Command); len(stages) > 0 && !opts.PlainPreview {
+		fmt.Fprintln(w, tcolor.Bold("Pipeline"))
+		fmt.Fprintln(w, g.Rule)
+		var table tcolor.Table
+		for i, typ := range stageTypes(ctx, stages) {
+			typeCol := tcolor.Muted
+			if typ == "missing" {
+				typeCol = tcolor.Failure
+			}
+			table.Add(tcolor.Muted(fmt.Sprintf("%d.", i+1)), stages[i], typeCol(typ))
+		}
+		table.WriteTo(w)
+		fmt.Fprintln(w)
+	}
+	// Notes are optional, so the preview is still rendered if they can't be loaded.
+	if notes, err := LoadNotes(); err == nil && notes[e.Command] != "" {
+		fmt.Fprintln(w, tcolor.Bold("Notes"))
+		fmt.Fprintln(w, g.Rule)
+		fmt.Fprintln(w, notes[e.Command])
+		fmt.Fprintln(w)
+	}
+	// Sections using the command's history are omitted if it can't be loaded in time.
+	wg.Wait()
+
+	fmt.Fprintln(w, tcolor.Bold("Execution Details"))
+	fmt.Fprintln(w, g.Rule)
+	now := time.Now()
+	var details tcolor.Table
+	details.Add("When:", e.Time+" "+tcolor.Highlight(e.Relative(now)+" ago"))
+	dir := displayDir(e.Directory, opts.DirSegments)
+	if curDir, _ := os.Getwd(); !opts.NoCurrentDirMarker && newDirResolver(opts).same(e.Directory, curDir) {
+		dir = tcolor.Highlight(dir) + " " + tcolor.Muted(_curDirMarker)
+	}
+	details.Add("Directory:", dir)
+	exitCode := exitCol(e.Exit)
+	if glyph := g.statusGlyph(e.Exit); glyph != "" {
+		exitCode = exitCol(glyph + " " + e.Exit)
+	}
+	if sig := exitSignal(e.Exit); sig != "" {
+		exitCode += " " + tcolor.Warning("(terminated by "+sig+")")
+	}
+	details.Add("Exit Code:", exitCode)
+	details.Add("Duration:", displayDuration(e.Duration))
+	if opts.AvgDuration && runsErr == nil {
+		if avg, n := averageDuration(runs); n >= _minAverageRuns {
+			details.Add("Average:", formatDuration(avg)+" "+tcolor.Muted(fmt.Sprintf("(over %d runs)", n)))
+		}
+	}
+	details.WriteTo(w)
+	fmt.Fprintln(w)
+	if showLastSuccess && runsErr == nil {
+		fmt.Fprintln(w, tcolor.Bold("Last Success"))
+		fmt.Fprintln(w, g.Rule)
+		if last, ok := lastSuccess(runs, e); ok {
+			when := tcolor.Highlight(last.Relative(now) + " ago")
+			if gap, ok := timeBetween(last, e); ok {
+				when += " " + tcolor.Muted(gap+" before this run")
+			}
+			dir := displayDir(last.Directory, opts.DirSegments)
+			if last.Directory == e.Directory {
+				dir += " " + tcolor.Muted("(same directory)")
+			}
+			var table tcolor.Table
+			table.Add("When:", last.Time+" "+when)
+			table.Add("Directory:", dir)
+			table.WriteTo(w)
+		} else {
+			fmt.Fprintln(w, tcolor.Muted(fmt.Sprintf("No earlier successful run in the last %d runs", _commandHistoryLimit)))
+		}
+		fmt.Fprintln(w)
+	}
+	if opts.UsualDir && runsErr == nil {
+		writeUsualDir(w, runs, opts, g.Rule)
+	}
+	if opts.Timeline && runsErr == nil {
+		fmt.Fprintln(w, tcolor.Bold(fmt.Sprintf("Timeline (last %d days)", _timelineDays)))
+		fmt.Fprintln(w, g.Rule)
+		fmt.Fprintln(w, timeline(runs, now, g.Spark))
+		fmt.Fprintln(w)
+	}
+	if opts.PlainPreview {
+		return nil
+	}
+
+	fmt.Fprintln(w, tcolor.Bold("Recent Similar Commands"))
+	fmt.Fprintln(w, g.Rule)
+
+	if similarErr != nil {
+		return similarErr
+	}
+	for _, r := range append(recent, recentInDir...) {
+		fmt.Fprintf(w, "%s %s %s\n%s\n",
+			tcolor.Highlight(r.Relative(now)),
+			tcolor.Muted(displayDir(r.Directory, opts.DirSegments)),
+			exitColor(r.Exit, g),
+			tcolor.Bold("$ ")+similarCommand(r.Command, opts, g),
+		)
+	}
+	// Sections that didn't load before the timeout are left out, which isn't an error.
+	return nil
+}
+
+// similarCommands returns the most recent commands similar to e's, and those run in e's directory.
+// A single search is used for both, to avoid an atuin process per section.
+func similarCommands(ctx context.Context, s Searcher, e Entry) (recent, inDir []Entry, _ error) {
+	results, err := s.Search(ctx, SearchParams{
+		Query: e.Command,
+		Limit: _similarSearchLimit,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for r := range results {
+		if r.Error != nil {
+			return nil, nil, r.Error
+		}
+		switch {
+		case len(recent) < _similarCount:
+			recent = append(recent, r)
+		case len(inDir) < _similarCount && r.Directory == e.Directory && !slices.Contains(recent, r):
+			inDir = append(inDir, r)
+		}
+		if len(recent) == _similarCount && len(inDir) == _similarCount {
+			break
+		}
+	}
+	return recent, inDir, nil
+}
+
+// stageTypes returns the commandType of each pipeline stage,
+// looked up concurrently since each starts the user's shell.
+func stageTypes(ctx context.Context, stages []string) []string {
+	types := make([]string, len(stages))
+	var wg sync.WaitGroup
+	for i, stage := range stages {
+		wg.Go(func() {
+			types[i] = commandType(ctx, stage)
+		})
+	}
+	wg.Wait()
+	return types
+}
+
+// displayDuration returns atuin's {duration} for display, noting when it wasn't
+// recorded, which atuin reports as a missing or zero duration.
+func displayDuration(duration string) string {
+	if d, err := ParseDuration(duration); duration == "" || (err == nil && d <= 0) {
+		return tcolor.Muted("(not recorded)")
+	}
+	return duration
+}
+
+// _minAverageRuns is the minimum number of runs with a duration to show their average.
+const _minAverageRuns = 2
+
+// averageDuration returns the average of the recorded durations of runs,
+// and the number of runs with a recorded duration.
+func averageDuration(runs []Entry) (time.Duration, int) {
+	var (
+		total time.Duration
+		n     int
+	)
+	for _, r := range runs {
+		if d, err := ParseDuration(r.Duration); err == nil && d > 0 {
+			total += d
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return total / time.Duration(n), n
+}
+
+// formatDuration formats d like atuin's {duration}, rounded to a precision that suits its size.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
+
+// _signalNames are the names of common signals, by number.
+var _signalNames = map[int]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	3:  "SIGQUIT",
+	4:  "SIGILL",
+	6:  "SIGABRT",
+	8:  "SIGFPE",
+	9:  "SIGKILL",
+	11: "SIGSEGV",
+	13: "SIGPIPE",
+	14: "SIGALRM",
+	15: "SIGTERM",
+}
+
+// exitSignal returns the name of the signal that shells report as exit code 128+N,
+// or "" if the exit code isn't from a known signal.
+func exitSignal(exitCode string) string {
+	code, err := strconv.Atoi(exitCode)
+	if err != nil || code <= 128 {
+		return ""
+	}
+	return _signalNames[code-128]
+}
+
+// lastSuccess returns the most recent successful run in runs
+// that was run before the failed entry e.
+func lastSuccess(runs []Entry, e Entry) (Entry, bool) {
+	failedAt, err := ParseTime(e.Time)
+	if err != nil {
+		return Entry{}, false
+	}
+
+	var (
+		last   Entry
+		lastAt time.Time
+	)
+	for _, r := range runs {
+		if r.Exit != "0" {
+			continue
+		}
+		t, err := ParseTime(r.Time)
+		if err != nil || !t.Before(failedAt) || t.Before(lastAt) {
+			continue
+		}
+		last, lastAt = r, t
+	}
+	return last, !lastAt.IsZero()
+}
+
+// timeBetween returns the time from the run of earlier to the run of later.
+func timeBetween(earlier, later Entry) (string, bool) {
+	t1, err1 := ParseTime(earlier.Time)
+	t2, err2 := ParseTime(later.Time)
+	if err1 != nil || err2 != nil {
+		return "", false
+	}
+	return formatRelative(t2.Sub(t1)), true
+}
+
+// similarCommand returns command for display in the similar commands,
+// truncated to a single line that fits opts.SimilarWidth after the "$ " prompt.
+func similarCommand(command string, opts Options, g glyphs) string {
+	if opts.SimilarWidth <= 0 {
+		return previewCommand(command, opts.PreviewMaxBytes, g)
+	}
+
+	command = strings.ToValidUTF8(command, "\uFFFD")
+	line, _, multiline := strings.Cut(command, "\n")
+	// Tabs are displayed wider than a column, so they're replaced to fit the width.
+	line = strings.ReplaceAll(line, "\t", " ")
+	width := max(opts.SimilarWidth-2, 1) // for the "$ " prompt
+	if !multiline && utf8.RuneCountInString(line) <= width {
+		return line
+	}
+
+	runes := []rune(line)
+	keep := max(width-utf8.RuneCountInString(g.Ellipsis), 0)
+	return string(runes[:min(keep, len(runes))]) + g.Ellipsis
+}
+
+// previewCommand returns command for display in the preview, truncated to maxBytes
+// with a footer noting its full size. If maxBytes <= 0, the command isn't truncated.
+func previewCommand(command string, maxBytes int, g glyphs) string {
+	command = strings.ToValidUTF8(command, "\uFFFD")
+	if maxBytes <= 0 || len(command) <= maxBytes {
+		return command
+	}
+
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(command[cut]) {
+		cut--
+	}
+	return command[:cut] + g.Ellipsis + "\n" + tcolor.Muted(fmt.Sprintf("(truncated, %d bytes total)", len(command)))
+}
+
+// shortenHome returns s with the home directory shortened to ~. It's unchanged if $HOME
+// is unset, or isn't an absolute path other than the root, as in some minimal environments.
+func shortenHome(s string) string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil || !filepath.IsAbs(homeDir) {
+		return s
+	}
+	homeDir = filepath.Clean(homeDir)
+	if homeDir == string(filepath.Separator) {
+		return s
+	}
+
+	if s == homeDir {
+		return "~"
+	}
+	if suffix, ok := strings.CutPrefix(s, homeDir+string(filepath.Separator)); ok {
+		return filepath.Join("~", suffix)
+	}
+	return s
+}
+
+// displayDir returns dir for display, with the home directory shortened to ~,
+// and if maxSegments > 0, middle path segments elided to fit maxSegments.
+func displayDir(dir string, maxSegments int) string {
+	dir = shortenHome(dir)
+	if maxSegments <= 0 {
+		return dir
+	}
+
+	sep := string(filepath.Separator)
+	root, rest := "", dir
+	if after, ok := strings.CutPrefix(dir, sep); ok {
+		root, rest = sep, after
+	}
+
+	segments := strings.Split(rest, sep)
+	if len(segments) <= maxSegments {
+		return dir
+	}
+
+	head := (maxSegments + 1) / 2
+	tail := maxSegments - head
+	return root + filepath.Join(
+		filepath.Join(segments[:head]...),
+		"...",
+		filepath.Join(segments[len(segments)-tail:]...),
+	)
+}
+
+// writeUsualDir writes the directory runs were most often run in,
+// unless no directory was used more than once.
+func writeUsualDir(w io.Writer, runs []Entry, opts Options, rule string) {
+	dir, n := usualDir(runs)
+	if n < 2 {
+		return
+	}
+
+	fmt.Fprintln(w, tcolor.Bold("Usually Run In"))
+	fmt.Fprintln(w, rule)
+	fmt.Fprintln(w, displayDir(dir, opts.DirSegments)+" "+tcolor.Muted(fmt.Sprintf("(%d of %d runs)", n, len(runs))))
+	fmt.Fprintln(w)
+}
+
+// usualDir returns the directory runs were most often run in, and the number of runs there.
+// If directories were used equally often, the first to reach that count is returned.
+func usualDir(runs []Entry) (dir string, n int) {
+	counts := make(map[string]int)
+	for _, r := range runs {
+		if r.Directory == "" {
+			continue
+		}
+		counts[r.Directory]++
+		if counts[r.Directory] > n {
+			dir, n = r.Directory, counts[r.Directory]
+		}
+	}
+	return dir, n
+}
@@ -0,0 +1,139 @@
+package atuinfzf
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prashantv/atuin-fzf/tcolor"
+)
+
+var _update = flag.Bool("update", false, "update the golden files in testdata")
+
+// stubSearcher is a Searcher that yields the same entries for every search.
+type stubSearcher []Entry
+
+func (s stubSearcher) Search(ctx context.Context, p SearchParams) (iter.Seq[Entry], error) {
+	return slices.Values(s), nil
+}
+
+// checkGolden compares got to the golden file testdata/name.golden,
+// updating it instead with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".golden")
+	if *_update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("update golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file, run with -update to create it: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("preview doesn't match %v, run with -update if the change is expected\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestRenderPreviewGolden(t *testing.T) {
+	// Avoid the user's shell, PATH, notes and home directory affecting the preview.
+	t.Setenv("SHELL", "")
+	t.Setenv("PATH", "")
+	t.Setenv("HOME", "/home/me")
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	history := stubSearcher{
+		{Time: "2025-01-02 11:00:00", RelativeTime: "5m", Duration: "2s", Exit: "0", Directory: "/home/me/src/app", Command: "make test"},
+		{Time: "2025-01-02 10:30:00", RelativeTime: "35m", Duration: "40s", Exit: "2", Directory: "/home/me/src/app", Command: "make test"},
+		{Time: "2025-01-02 09:00:00", RelativeTime: "2h", Duration: "1s", Exit: "0", Directory: "/srv", Command: "make build"},
+		{Time: "2025-01-01 09:00:00", RelativeTime: "1d", Duration: "3s", Exit: "0", Directory: "/home/me/src/app", Command: "make test"},
+	}
+
+	tests := []struct {
+		name  string
+		entry Entry
+	}{
+		{
+			name:  "success",
+			entry: history[0],
+		},
+		{
+			name:  "failure",
+			entry: history[1],
+		},
+		{
+			name:  "missing_directory",
+			entry: Entry{Time: "2025-01-02 08:00:00", RelativeTime: "3h", Duration: "", Exit: "0", Command: "uptime"},
+		},
+		{
+			name:  "long_command",
+			entry: Entry{Time: "2025-01-02 08:00:00", RelativeTime: "3h", Duration: "1s", Exit: "0", Directory: "/srv", Command: "echo " + strings.Repeat("abcdefghij", 10)},
+		},
+		{
+			name:  "cjk_command",
+			entry: Entry{Time: "2025-01-02 08:00:00", RelativeTime: "3h", Duration: "1s", Exit: "0", Directory: "/home/me/文档", Command: "echo 你好，世界"},
+		},
+	}
+
+	opts := Options{
+		AvgDuration:     true,
+		LastSuccess:     true,
+		UsualDir:        true,
+		PreviewMaxBytes: 64,
+	}
+	for _, color := range []bool{true, false} {
+		suffix := "_plain"
+		if color {
+			suffix = "_color"
+		}
+		for _, tt := range tests {
+			t.Run(tt.name+suffix, func(t *testing.T) {
+				tcolor.SetEnabled(color)
+				defer tcolor.SetEnabled(true)
+
+				var buf bytes.Buffer
+				if err := RenderPreviewWith(context.Background(), &buf, tt.entry, opts, history); err != nil {
+					t.Fatalf("RenderPreviewWith: %v", err)
+				}
+				checkGolden(t, "preview_"+tt.name+suffix, buf.Bytes())
+			})
+		}
+	}
+}
+
+// blockingSearcher is a Searcher whose searches yield nothing until ctx is done.
+type blockingSearcher struct{}
+
+func (blockingSearcher) Search(ctx context.Context, p SearchParams) (iter.Seq[Entry], error) {
+	return func(yield func(Entry) bool) {
+		<-ctx.Done()
+	}, nil
+}
+
+func TestRenderPreviewCanceled(t *testing.T) {
+	t.Setenv("SHELL", "")
+	t.Setenv("PATH", "")
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	e := Entry{Time: "2025-01-02 10:00:00", RelativeTime: "5m", Exit: "0", Directory: "/srv", Command: "make test"}
+	if err := RenderPreviewWith(ctx, &buf, e, Options{Timeline: true}, blockingSearcher{}); err != nil {
+		t.Fatalf("RenderPreviewWith after the searches timed out: %v", err)
+	}
+	if !strings.Contains(buf.String(), "make test") {
+		t.Errorf("preview is missing the command:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,58 @@
+package atuinfzf
+
+import "path/filepath"
+
+// lastQueries are the last queries used in fzf, keyed by their scope,
+// which is empty for the global query, or a directory.
+type lastQueries map[string]string
+
+func queriesPath() (string, error) {
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "queries.json"), nil
+}
+
+func loadLastQueries() (lastQueries, error) {
+	p, err := queriesPath()
+	if err != nil {
+		return nil, err
+	}
+
+	queries := make(lastQueries)
+	if err := readJSON(p, &queries); err != nil {
+		return nil, err
+	}
+	return queries, nil
+}
+
+// LastQuery returns the last query saved for scope, which is empty
+// for the global query, or a directory.
+func LastQuery(scope string) (string, error) {
+	queries, err := loadLastQueries()
+	if err != nil {
+		return "", err
+	}
+	return queries[scope], nil
+}
+
+// SaveLastQuery saves the last query for scope, removing it if query is empty.
+func SaveLastQuery(scope, query string) error {
+	queries, err := loadLastQueries()
+	if err != nil {
+		return err
+	}
+
+	if query == "" {
+		delete(queries, scope)
+	} else {
+		queries[scope] = query
+	}
+
+	p, err := queriesPath()
+	if err != nil {
+		return err
+	}
+	return writeJSON(p, queries)
+}
@@ -0,0 +1,613 @@
+package atuinfzf
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prashantv/atuin-fzf/tcolor"
+)
+
+// Delim separates the fields of rows passed to fzf.
+const Delim = "\t:::\t"
+
+// Fields of a row passed to fzf, numbered as in fzf's field placeholders.
+const (
+	FieldDisplay = iota + 1
+	FieldExit
+	FieldDirectory
+	FieldDuration
+	FieldTime
+	FieldRelativeTime
+	FieldExitStatus
+	FieldDirContext
+	FieldStatusBadge
+	FieldFavorite
+	FieldCount
+	FieldPrefix
+	FieldRank
+
+	// FieldDisplayDirectory is the directory for display, shortened like the preview's,
+	// while FieldDirectory is the directory the command was run in.
+	FieldDisplayDirectory
+
+	// FieldCommand is the original command. It's the last field so it may
+	// contain anything, including the delimiter.
+	FieldCommand
+
+	_numFields = FieldCommand
+)
+
+// _rowVersion must be incremented whenever the meaning of the fields changes.
+const _rowVersion = 1
+
+// RowFormat identifies the layout of rows, so data passed between processes,
+// which may be different versions of atuin-fzf, can be checked before decoding.
+var RowFormat = fmt.Sprintf("atuin-fzf-row.v%d.%d", _rowVersion, _numFields)
+
+// Row holds the fields of a row passed to fzf, indexed by field number.
+type Row [_numFields + 1]string
+
+// Encode returns the row as a line for fzf.
+//
+// Tabs and zero-width spaces in all fields but the last are replaced, so they
+// can never contain the delimiter, and can be used to separate displayed fields.
+func (r Row) Encode() string {
+	var sb strings.Builder
+	for f := FieldDisplay; f <= _numFields; f++ {
+		v := r[f]
+		if f != _numFields {
+			v = _fieldReplacer.Replace(v)
+			v += Delim
+		}
+		sb.WriteString(v)
+	}
+	return sb.String()
+}
+
+var _fieldReplacer = strings.NewReplacer("\t", " ", "\u200b", "")
+
+// IsSeparator returns whether the row is a GroupByTime or GroupBySession separator, rather than an entry.
+func (r Row) IsSeparator() bool {
+	return r[FieldCommand] == ""
+}
+
+// Entry returns the history entry the row was created from.
+func (r Row) Entry() Entry {
+	return Entry{
+		Time:         r[FieldTime],
+		RelativeTime: r[FieldRelativeTime],
+		Duration:     r[FieldDuration],
+		Exit:         r[FieldExit],
+		Directory:    r[FieldDirectory],
+		Command:      r[FieldCommand],
+	}
+}
+
+// DecodeRow parses a line produced by Row.Encode, such as the
+// data passed to the preview.
+func DecodeRow(s string) (Row, error) {
+	var r Row
+	parts := strings.SplitN(s, Delim, _numFields)
+	if len(parts) != _numFields {
+		return r, fmt.Errorf("data format incorrect, expected %d fields, got %d in %q", _numFields, len(parts), s)
+	}
+	copy(r[FieldDisplay:], parts)
+	return r, nil
+}
+
+// FieldRef returns the fzf placeholder for field f.
+func FieldRef(f int) string {
+	if f == _numFields {
+		// Include any delimiters contained in the last field.
+		return fmt.Sprintf("{%d..}", f)
+	}
+	return fmt.Sprintf("{%d}", f)
+}
+
+// rowEnv is the environment rows are created in.
+type rowEnv struct {
+	// curDir is used to mark entries run in the current directory, if set.
+	curDir    string
+	dirs      *dirResolver
+	favorites Favorites
+}
+
+// newRow returns the row for e, which was run count times if deduplicated.
+func newRow(e Entry, count int, env rowEnv, opts Options) Row {
+	var r Row
+	// Commands are sanitized and truncated for display, while the
+	// original command is used for the selection.
+	display := e.Command
+	if opts.ByDir {
+		// The command is the directory, with the home directory shortened for display.
+		display = shortenHome(display)
+	}
+	if opts.Normalize {
+		display = NormalizeCommand(display)
+	}
+	if opts.IgnorePrefixes {
+		// The prefix is displayed separately, so it can be excluded from matching.
+		var prefix string
+		prefix, display = SplitCommandPrefix(display)
+		r[FieldPrefix] = strings.ToValidUTF8(prefix, "\uFFFD")
+	}
+	g := opts.glyphs()
+	r[FieldDisplay] = displayCommand(display, g)
+	if width := tcolor.VisibleWidth(r[FieldPrefix] + r[FieldDisplay]); width < opts.MetaColumn {
+		r[FieldDisplay] += strings.Repeat(" ", opts.MetaColumn-width)
+	}
+	r[FieldExit] = e.Exit
+	r[FieldDirectory] = e.Directory
+	if e.Directory != "" {
+		r[FieldDisplayDirectory] = displayDir(e.Directory, opts.DirSegments)
+	}
+	r[FieldDuration] = e.Duration
+	r[FieldTime] = e.Time
+	r[FieldRelativeTime] = e.RelativeTime
+	if !opts.NoExitStatus {
+		r[FieldExitStatus] = exitColor(e.Exit, g)
+	}
+	if env.dirs.same(e.Directory, env.curDir) {
+		r[FieldDirContext] = tcolor.Muted(_curDirMarker)
+	}
+	if opts.StatusBadge {
+		r[FieldStatusBadge] = statusBadge(e.Exit, g)
+	}
+	if _, ok := env.favorites[e.Command]; ok {
+		r[FieldFavorite] = tcolor.Warning(g.Favorite)
+	}
+	if count > 1 {
+		r[FieldCount] = countBadge(count)
+	}
+	r[FieldCommand] = e.Command
+	return r
+}
+
+// _curDirMarker marks entries run in the current directory.
+const _curDirMarker = "(same cwd)"
+
+// dirResolver compares directories, ignoring trailing separators,
+// and with ResolveSymlinks, resolving symlinks. Resolved directories are cached,
+// as each unique directory requires filesystem calls.
+type dirResolver struct {
+	resolveSymlinks bool
+	resolved        map[string]string
+}
+
+func newDirResolver(opts Options) *dirResolver {
+	return &dirResolver{
+		resolveSymlinks: opts.ResolveSymlinks,
+		resolved:        make(map[string]string),
+	}
+}
+
+// canonical returns dir cleaned, with symlinks resolved if enabled.
+// Directories that can't be resolved, such as those that no longer exist, are only cleaned.
+func (d *dirResolver) canonical(dir string) string {
+	dir = filepath.Clean(dir)
+	if !d.resolveSymlinks {
+		return dir
+	}
+	if resolved, ok := d.resolved[dir]; ok {
+		return resolved
+	}
+
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		resolved = dir
+	}
+	d.resolved[dir] = resolved
+	return resolved
+}
+
+// same returns whether dir is curDir. It's false if curDir is unknown.
+func (d *dirResolver) same(dir, curDir string) bool {
+	return curDir != "" && d.canonical(dir) == d.canonical(curDir)
+}
+
+// WriteRows writes the null-terminated fzf rows for entries to w.
+func WriteRows(w io.Writer, entries iter.Seq[Entry], opts Options) error {
+	favorites, err := LoadFavorites()
+	if err != nil {
+		return err
+	}
+
+	curDir, _ := os.Getwd() // best effort
+	dirs := newDirResolver(opts)
+	env := rowEnv{dirs: dirs, favorites: favorites}
+	if !opts.NoCurrentDirMarker {
+		env.curDir = curDir
+	}
+
+	// With DebugRank, rows are written once they're all loaded, so they can be ranked.
+	var ranked []rankedRow
+	write := func(r Row, count int) error {
+		if opts.DebugRank {
+			ranked = append(ranked, rankedRow{row: r, count: count})
+			return nil
+		}
+		_, err := io.WriteString(w, r.Encode()+string(byte(0)))
+		return err
+	}
+
+	// With GroupByTime or GroupBySession, a separator is written whenever the group changes.
+	var (
+		now   = time.Now()
+		group string // the group of the last entry written.
+	)
+	writeSeparator := func(e Entry) error {
+		g := entryGroup(e, now, opts)
+		if g == "" || g == group {
+			return nil
+		}
+
+		// fzf reverses the rows, so labels are written before their entries when
+		// the newest entries are displayed last, and after them otherwise.
+		label := g
+		if opts.NewestFirst {
+			label = group
+		}
+		group = g
+		if label == "" {
+			return nil
+		}
+		return write(separatorRow(label, opts.glyphs()), 0)
+	}
+	encodeRow := func(e Entry, count int) error {
+		if opts.GroupByTime || opts.GroupBySession {
+			if err := writeSeparator(e); err != nil {
+				return err
+			}
+		}
+		return write(newRow(e, count, env, opts), count)
+	}
+
+	// With SmartDir, entries run in the current directory are written last,
+	// so they're listed closest to the prompt, and rank above other entries.
+	var curDirEntries []*dedupEntry
+	writeRow := func(e Entry, count int) error {
+		if opts.SmartDir && dirs.same(e.Directory, curDir) {
+			curDirEntries = append(curDirEntries, &dedupEntry{entry: e, count: count})
+			return nil
+		}
+		return encodeRow(e, count)
+	}
+
+	// With CollapseRuns, run holds the consecutive runs of the last command.
+	var (
+		deduped dedupEntries
+		run     dedupEntry
+	)
+	for e := range entries {
+		if e.Error != nil {
+			return fmt.Errorf("read atuin history: %w", e.Error)
+		}
+		if _, ok := favorites[e.Command]; opts.FavoritesOnly && !ok {
+			continue
+		}
+		if opts.Program != "" && CommandProgram(e.Command) != opts.Program {
+			continue
+		}
+		if opts.Dir != "" && !dirs.same(e.Directory, opts.Dir) {
+			continue
+		}
+		if exclude := strings.TrimSpace(opts.ExcludeCommand); exclude != "" && strings.TrimSpace(e.Command) == exclude {
+			continue
+		}
+		if opts.CommandsOnly {
+			if e.Command = CommandProgram(e.Command); e.Command == "" {
+				continue
+			}
+		}
+		if opts.ByDir {
+			if e.Directory == "" {
+				continue
+			}
+			e.Command = dirs.canonical(e.Directory)
+		}
+
+		if opts.Dedup || opts.CommandsOnly || opts.ByDir {
+			deduped.add(e, opts)
+			continue
+		}
+		if opts.CollapseRuns {
+			if run.count > 0 && commandKey(run.entry.Command, opts) != commandKey(e.Command, opts) {
+				if err := writeRow(run.entry, run.count); err != nil {
+					return err
+				}
+				run.count = 0
+			}
+			run.entry = e
+			run.count++
+			continue
+		}
+		if err := writeRow(e, 1); err != nil {
+			return err
+		}
+	}
+
+	if run.count > 0 {
+		if err := writeRow(run.entry, run.count); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range deduped.sorted() {
+		if err := writeRow(d.entry, d.count); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range curDirEntries {
+		if err := encodeRow(d.entry, d.count); err != nil {
+			return err
+		}
+	}
+
+	if opts.NewestFirst && group != "" {
+		if err := write(separatorRow(group, opts.glyphs()), 0); err != nil {
+			return err
+		}
+	}
+
+	if opts.DebugRank {
+		if err := writeRanked(w, ranked, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.Hint != "" {
+		_, err := io.WriteString(w, separatorRow(opts.Hint, opts.glyphs()).Encode()+string(byte(0)))
+		return err
+	}
+	return nil
+}
+
+// rankedRow is a row written with DebugRank, which was run count times.
+type rankedRow struct {
+	row   Row
+	count int
+}
+
+// writeRanked writes rows annotated with their rank in the list without a query.
+// fzf lists the last row first, and ranks rows that match equally by their order.
+func writeRanked(w io.Writer, rows []rankedRow, opts Options) error {
+	rank := 0
+	for i := len(rows) - 1; i >= 0; i-- {
+		if rows[i].row.IsSeparator() {
+			continue
+		}
+		rank++
+		annotation := fmt.Sprintf("#%d", rank)
+		if opts.Dedup || opts.CommandsOnly || opts.ByDir || opts.CollapseRuns {
+			annotation += fmt.Sprintf(", x%d", rows[i].count)
+		}
+		rows[i].row[FieldRank] = tcolor.Muted("[" + annotation + "]")
+	}
+
+	for _, r := range rows {
+		if _, err := io.WriteString(w, r.row.Encode()+string(byte(0))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// separatorRow returns a separator row, labeled with the group of the entries it separates.
+func separatorRow(label string, g glyphs) Row {
+	var r Row
+	r[FieldDisplay] = tcolor.Muted(g.Dash + " " + label + " " + g.Dash)
+	return r
+}
+
+// _sessionIDLen is the length of session IDs in separator labels,
+// which is enough to tell sessions apart.
+const _sessionIDLen = 8
+
+// entryGroup returns the label for the GroupByTime and GroupBySession groups of e,
+// or an empty string if e isn't in a group.
+func entryGroup(e Entry, now time.Time, opts Options) string {
+	var labels []string
+	if opts.GroupByTime {
+		if b := entryTimeBucket(e, now); b != "" {
+			labels = append(labels, b)
+		}
+	}
+	if opts.GroupBySession && e.Session != "" {
+		session := e.Session
+		if len(session) > _sessionIDLen {
+			session = session[:_sessionIDLen]
+		}
+		labels = append(labels, "Session "+session)
+	}
+	return strings.Join(labels, ", ")
+}
+
+// entryTimeBucket returns the label for when e was run relative to now,
+// or an empty string if e's time can't be parsed.
+func entryTimeBucket(e Entry, now time.Time) string {
+	t, err := ParseTime(e.Time)
+	if err != nil {
+		return ""
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch {
+	case !t.Before(today):
+		return "Today"
+	case !t.Before(today.AddDate(0, 0, -1)):
+		return "Yesterday"
+	case !t.Before(today.AddDate(0, 0, -7)):
+		return "Last week"
+	case !t.Before(today.AddDate(0, -1, 0)):
+		return "Last month"
+	default:
+		return "Older"
+	}
+}
+
+// dedupEntries collapses entries with the same command into the last entry.
+type dedupEntries struct {
+	byCommand map[string]*dedupEntry
+	n         int
+}
+
+type dedupEntry struct {
+	entry Entry
+	count int
+	last  int // index of the last occurrence
+}
+
+func (d *dedupEntries) add(e Entry, opts Options) {
+	if d.byCommand == nil {
+		d.byCommand = make(map[string]*dedupEntry)
+	}
+
+	key := commandKey(e.Command, opts)
+	d.n++
+	de, ok := d.byCommand[key]
+	if !ok {
+		de = &dedupEntry{}
+		d.byCommand[key] = de
+	}
+	de.entry = e
+	de.count++
+	de.last = d.n
+}
+
+// commandKey returns the key used to compare commands when deduplicating.
+func commandKey(command string, opts Options) string {
+	if opts.Normalize {
+		return NormalizeCommand(command)
+	}
+	return command
+}
+
+// sorted returns the entries in the order of their last occurrence.
+func (d *dedupEntries) sorted() []*dedupEntry {
+	entries := slices.Collect(maps.Values(d.byCommand))
+	slices.SortFunc(entries, func(a, b *dedupEntry) int {
+		return cmp.Compare(a.last, b.last)
+	})
+	return entries
+}
+
+// countBadge returns the number of times a command was run, colored warmer
+// for more frequently run commands.
+func countBadge(count int) string {
+	badge := fmt.Sprintf("(x%d)", count)
+	switch {
+	case count >= 100:
+		return tcolor.Style{}.Bold().Foreground(tcolor.Current().Warning).Render(badge)
+	case count >= 20:
+		return tcolor.Warning(badge)
+	case count >= 5:
+		return tcolor.Highlight(badge)
+	default:
+		return tcolor.Muted(badge)
+	}
+}
+
+// _maxDisplayLen is the maximum length of a command displayed in the list.
+const _maxDisplayLen = 4096
+
+// NormalizeCommand trims whitespace around command, and collapses runs of
+// spaces and tabs within each line to a single space.
+func NormalizeCommand(command string) string {
+	lines := strings.Split(strings.TrimSpace(command), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t'
+		}), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// displayCommand returns command sanitized and truncated for display.
+// The displayed command is matched by fzf, so it's never colored, and any
+// control characters are escaped, so fzf's highlighting of matches isn't masked.
+func displayCommand(command string, g glyphs) string {
+	command = escapeControl(strings.ToValidUTF8(command, "\uFFFD"))
+	if len(command) <= _maxDisplayLen {
+		return command
+	}
+
+	cut := _maxDisplayLen
+	for cut > 0 && !utf8.RuneStart(command[cut]) {
+		cut--
+	}
+	return command[:cut] + g.Ellipsis
+}
+
+// escapeControl replaces control characters in s other than tabs and newlines
+// with their caret notation, e.g. ^[ for escape, which fzf would otherwise
+// interpret as the start of an ANSI sequence with --ansi.
+func escapeControl(s string) string {
+	isControl := func(r rune) bool {
+		return (r < ' ' && r != '\t' && r != '\n') || r == 0x7f
+	}
+	if !strings.ContainsFunc(s, isControl) {
+		return s
+	}
+
+	var sb strings.Builder
+	for _, r := range s {
+		if isControl(r) {
+			sb.WriteByte('^')
+			sb.WriteByte(byte(r) ^ 0x40)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// _statusBadgeWidth fits any exit code, so the command always starts at the same column.
+const _statusBadgeWidth = 3
+
+// statusBadge returns a fixed-width badge for the exit code.
+func statusBadge(exitCode string, g glyphs) string {
+	width := _statusBadgeWidth
+	if tcolor.Current().StatusGlyphs {
+		// Leave room for the failure glyph before the exit code.
+		width++
+	}
+	switch code, err := strconv.Atoi(exitCode); {
+	case err != nil || code < 0:
+		return tcolor.Muted(fmt.Sprintf("%*s", width, "-"))
+	case code == 0:
+		success := g.Success
+		if glyph := g.statusGlyph(exitCode); glyph != "" {
+			success = glyph
+		}
+		return tcolor.Success(fmt.Sprintf("%*s", width, success))
+	default:
+		return tcolor.Failure(fmt.Sprintf("%*s", width, g.statusGlyph(exitCode)+strconv.Itoa(code)))
+	}
+}
+
+// exitColor returns the marker for exitCode in rows, which is empty for successes
+// unless the theme marks statuses with glyphs.
+func exitColor(exitCode string, g glyphs) string {
+	glyph := g.statusGlyph(exitCode)
+	if exitCode != "0" {
+		if glyph != "" {
+			glyph += " "
+		}
+		return tcolor.Failure(glyph + "exit " + exitCode)
+	}
+	if glyph != "" {
+		return tcolor.Success(glyph)
+	}
+	return ""
+}
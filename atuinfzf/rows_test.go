@@ -0,0 +1,247 @@
+package atuinfzf
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/prashantv/atuin-fzf/tcolor"
+)
+
+func TestNewRowInvalidUTF8(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     string
+		wantDisplay string
+	}{
+		{
+			name:        "valid",
+			command:     "echo café",
+			wantDisplay: "echo café",
+		},
+		{
+			name:        "invalid byte",
+			command:     "echo \xff",
+			wantDisplay: "echo \uFFFD",
+		},
+		{
+			name:        "truncated rune",
+			command:     "echo caf\xc3",
+			wantDisplay: "echo caf\uFFFD",
+		},
+		{
+			name:        "invalid run",
+			command:     "printf \xff\xfe\xfd done",
+			wantDisplay: "printf \uFFFD done",
+		},
+		{
+			name:        "control characters",
+			command:     "printf \x1b[31mred",
+			wantDisplay: "printf ^[[31mred",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{}
+			env := rowEnv{dirs: newDirResolver(opts)}
+			row := newRow(Entry{Exit: "0", Command: tt.command}, 1, env, opts)
+			if got := row[FieldDisplay]; got != tt.wantDisplay {
+				t.Errorf("display %q, want %q", got, tt.wantDisplay)
+			}
+
+			// The original command is selected, even if it's not valid UTF-8.
+			decoded, err := DecodeRow(row.Encode())
+			if err != nil {
+				t.Fatalf("DecodeRow: %v", err)
+			}
+			if got := decoded[FieldCommand]; got != tt.command {
+				t.Errorf("command %q, want %q", got, tt.command)
+			}
+		})
+	}
+}
+
+func TestNewRowMetaColumn(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     string
+		wantDisplay string
+	}{
+		{
+			name:        "ascii",
+			command:     "ls",
+			wantDisplay: "ls        ",
+		},
+		{
+			name:        "wide runes",
+			command:     "echo 你好",
+			wantDisplay: "echo 你好 ",
+		},
+		{
+			name:        "wider than the column",
+			command:     "echo 你好世界",
+			wantDisplay: "echo 你好世界",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{MetaColumn: 10}
+			env := rowEnv{dirs: newDirResolver(opts)}
+			row := newRow(Entry{Exit: "0", Command: tt.command}, 1, env, opts)
+			if got := row[FieldDisplay]; got != tt.wantDisplay {
+				t.Errorf("display %q, want %q", got, tt.wantDisplay)
+			}
+		})
+	}
+}
+
+func TestRowEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		row  Row
+		// want is the decoded row, if it differs from row.
+		want *Row
+	}{
+		{
+			name: "simple",
+			row:  Row{FieldDisplay: "ls", FieldExit: "0", FieldDirectory: "/srv", FieldCommand: "ls"},
+		},
+		{
+			name: "delimiter in command",
+			row:  Row{FieldDisplay: "echo x", FieldDirectory: "/srv", FieldCommand: "echo a" + Delim + "b" + Delim},
+		},
+		{
+			name: "tabs and newlines in command",
+			row:  Row{FieldDisplay: "printf", FieldCommand: "printf 'a\tb'\n\techo \u200bc"},
+		},
+		{
+			name: "separator",
+			row:  Row{FieldDisplay: "Today"},
+		},
+		{
+			name: "tabs in other fields",
+			row:  Row{FieldDisplay: "a\tb", FieldDirectory: "/tmp/x\ty", FieldCommand: "a\tb"},
+			want: &Row{FieldDisplay: "a b", FieldDirectory: "/tmp/x y", FieldCommand: "a\tb"},
+		},
+		{
+			name: "zero-width space in other fields",
+			row:  Row{FieldDisplay: "a\u200bb", FieldPrefix: "sudo\u200b", FieldCommand: "sudo a\u200bb"},
+			want: &Row{FieldDisplay: "ab", FieldPrefix: "sudo", FieldCommand: "sudo a\u200bb"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.row
+			if tt.want != nil {
+				want = *tt.want
+			}
+
+			got, err := DecodeRow(tt.row.Encode())
+			if err != nil {
+				t.Fatalf("DecodeRow: %v", err)
+			}
+			if got != want {
+				t.Errorf("round trip got %q, want %q", got, want)
+			}
+			if got.IsSeparator() != (want[FieldCommand] == "") {
+				t.Errorf("IsSeparator() = %v for command %q", got.IsSeparator(), want[FieldCommand])
+			}
+		})
+	}
+}
+
+func TestDecodeRowErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: ""},
+		{name: "no delimiters", data: "ls -la"},
+		{name: "missing fields", data: "ls" + Delim + "0" + Delim + "/srv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeRow(tt.data); err == nil {
+				t.Errorf("DecodeRow(%q) succeeded, want error", tt.data)
+			}
+		})
+	}
+}
+
+func TestStatusMarkers(t *testing.T) {
+	tests := []struct {
+		theme     string
+		ascii     bool
+		exitCode  string
+		wantExit  string
+		wantBadge string
+	}{
+		{theme: "dark", exitCode: "0", wantExit: "", wantBadge: "  ●"},
+		{theme: "dark", exitCode: "1", wantExit: "exit 1", wantBadge: "  1"},
+		{theme: "colorblind", exitCode: "0", wantExit: "✓", wantBadge: "   ✓"},
+		{theme: "colorblind", exitCode: "1", wantExit: "✗ exit 1", wantBadge: "  ✗1"},
+		{theme: "colorblind", exitCode: "127", wantExit: "✗ exit 127", wantBadge: "✗127"},
+		{theme: "colorblind", ascii: true, exitCode: "0", wantExit: "+", wantBadge: "   +"},
+		{theme: "colorblind", ascii: true, exitCode: "2", wantExit: "x exit 2", wantBadge: "  x2"},
+	}
+	tcolor.SetEnabled(false)
+	defer tcolor.SetEnabled(true)
+	defer tcolor.SetTheme(tcolor.DefaultTheme)
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v ascii=%v exit=%q", tt.theme, tt.ascii, tt.exitCode), func(t *testing.T) {
+			if err := tcolor.SetTheme(tt.theme); err != nil {
+				t.Fatal(err)
+			}
+			g := Options{ASCII: tt.ascii}.glyphs()
+			if got := exitColor(tt.exitCode, g); got != tt.wantExit {
+				t.Errorf("exitColor(%q) = %q, want %q", tt.exitCode, got, tt.wantExit)
+			}
+			if got := statusBadge(tt.exitCode, g); got != tt.wantBadge {
+				t.Errorf("statusBadge(%q) = %q, want %q", tt.exitCode, got, tt.wantBadge)
+			}
+		})
+	}
+}
+
+// benchEntries returns n synthetic entries, with commands repeated
+// so deduplication has work to do.
+func benchEntries(n int) []Entry {
+	programs := []string{"git", "make", "go", "ls", "kubectl"}
+	entries := make([]Entry, n)
+	for i := range entries {
+		entries[i] = Entry{
+			Time:         "2025-01-02 10:00:00",
+			RelativeTime: "5m",
+			Duration:     "1s",
+			Exit:         fmt.Sprint(i % 3),
+			Directory:    fmt.Sprintf("/home/me/src/project%d", i%20),
+			Command:      fmt.Sprintf("%v --flag=value arg%d", programs[i%len(programs)], i%500),
+		}
+	}
+	return entries
+}
+
+func BenchmarkWriteRows(b *testing.B) {
+	b.Setenv("XDG_DATA_HOME", b.TempDir())
+	entries := benchEntries(10000)
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "plain"},
+		{name: "dedup", opts: Options{Dedup: true}},
+		{name: "filtered", opts: Options{Program: "git"}},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := WriteRows(io.Discard, slices.Values(entries), tt.opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
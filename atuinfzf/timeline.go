@@ -0,0 +1,56 @@
+package atuinfzf
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prashantv/atuin-fzf/tcolor"
+)
+
+// _timelineDays is the number of days shown in the timeline.
+const _timelineDays = 28
+
+// timeline renders a sparkline of the number of runs per day,
+// over the last _timelineDays days ending at now, using levels from lowest to highest.
+func timeline(runs []Entry, now time.Time, levels []rune) string {
+	today := startOfDay(now)
+	counts := make([]int, _timelineDays)
+	for _, r := range runs {
+		t, err := ParseTime(r.Time)
+		if err != nil {
+			continue
+		}
+
+		daysAgo := int(today.Sub(startOfDay(t)).Hours()+12) / 24 // round for DST changes
+		if daysAgo >= 0 && daysAgo < _timelineDays {
+			counts[_timelineDays-1-daysAgo]++
+		}
+	}
+	return sparkline(counts, levels)
+}
+
+// sparkline renders counts as levels scaled to the maximum count.
+// Zero counts are rendered muted.
+func sparkline(counts []int, levels []rune) string {
+	maxCount := 0
+	for _, c := range counts {
+		maxCount = max(maxCount, c)
+	}
+
+	var sb strings.Builder
+	for _, c := range counts {
+		if c == 0 {
+			sb.WriteString(tcolor.Muted(string(levels[0])))
+			continue
+		}
+
+		level := (c*len(levels) - 1) / maxCount
+		sb.WriteString(tcolor.Highlight(string(levels[level])))
+	}
+	return sb.String()
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
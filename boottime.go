@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _kernBoottimeRE = regexp.MustCompile(`sec = (\d+)`)
+
+// bootTime returns when the machine booted, using /proc/uptime on Linux
+// and the kern.boottime sysctl on macOS.
+func bootTime() (time.Time, error) {
+	switch runtime.GOOS {
+	case "linux":
+		data, err := os.ReadFile("/proc/uptime")
+		if err != nil {
+			return time.Time{}, err
+		}
+		uptime, _, _ := strings.Cut(string(data), " ")
+		secs, err := strconv.ParseFloat(uptime, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse /proc/uptime: %w", err)
+		}
+		return time.Now().Add(-time.Duration(secs * float64(time.Second))), nil
+
+	case "darwin":
+		// Output is of the form: { sec = 1700000000, usec = 0 } Tue Nov 14 ...
+		out, err := exec.Command("sysctl", "-n", "kern.boottime").Output()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("sysctl kern.boottime: %w", err)
+		}
+		m := _kernBoottimeRE.FindSubmatch(out)
+		if m == nil {
+			return time.Time{}, fmt.Errorf("unexpected kern.boottime %q", out)
+		}
+		secs, err := strconv.ParseInt(string(m[1]), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(secs, 0), nil
+
+	default:
+		return time.Time{}, errors.New("boot time is not supported on " + runtime.GOOS)
+	}
+}
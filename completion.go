@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/prashantv/atuin-fzf/tcolor"
+)
+
+// _subcommands are the subcommands of atuin-fzf.
+var _subcommands = []string{"export", "inspect", "completion"}
+
+// _completionShells are the shells that completions can be generated for.
+var _completionShells = []string{"zsh", "bash", "fish"}
+
+// completionFlag is a flag to complete.
+type completionFlag struct {
+	Name   string
+	Usage  string
+	IsBool bool
+	Values []string // if set, the known values of the flag.
+}
+
+// completionFlags returns the flags to complete for the flag set fs,
+// excluding flags used internally by fzf binds.
+func completionFlags(fs *flag.FlagSet, values map[string][]string) []completionFlag {
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		if strings.HasSuffix(f.Usage, "(internal)") {
+			return
+		}
+
+		bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			Name:   f.Name,
+			Usage:  f.Usage,
+			IsBool: ok && bf.IsBoolFlag(),
+			Values: values[f.Name],
+		})
+	})
+	return flags
+}
+
+// completion writes the completion script for shell to w.
+func completion(shell string, w io.Writer) error {
+	mainFlags := completionFlags(newFlagSet(&options{}), map[string][]string{
+		"theme":    tcolor.ThemeNames(),
+		"scheme":   _fzfSchemes,
+		"tiebreak": _fzfTiebreaks,
+		"order":    {"newest-first", "newest-last"},
+		"finder":   {"fzf", "sk"},
+
+		"format-preset": slices.Sorted(maps.Keys(_formatPresets)),
+
+		"filter-mode": _atuinFilterModes,
+		"search-mode": _atuinSearchModes,
+	})
+
+	var fields string
+	exportFlags := completionFlags(newExportFlagSet(&exportOptions{}, &fields), map[string][]string{
+		"format":      {"json", "csv"},
+		"time-format": {"human", "raw"},
+
+		"filter-mode": _atuinFilterModes,
+		"search-mode": _atuinSearchModes,
+	})
+
+	inspectFlags := completionFlags(newInspectFlagSet(&options{}), map[string][]string{
+		"theme": tcolor.ThemeNames(),
+
+		"filter-mode": _atuinFilterModes,
+		"search-mode": _atuinSearchModes,
+	})
+
+	switch shell {
+	case "zsh":
+		writeZshCompletion(w, mainFlags, exportFlags, inspectFlags)
+	case "bash":
+		writeBashCompletion(w, mainFlags, exportFlags, inspectFlags)
+	case "fish":
+		writeFishCompletion(w, mainFlags, exportFlags, inspectFlags)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected one of: %v", shell, strings.Join(_completionShells, ", "))
+	}
+	return nil
+}
+
+func writeZshCompletion(w io.Writer, mainFlags, exportFlags, inspectFlags []completionFlag) {
+	zshSpecs := func(flags []completionFlag) string {
+		var specs []string
+		for _, f := range flags {
+			desc := strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`).Replace(f.Usage)
+			spec := "--" + f.Name + "[" + desc + "]"
+			if !f.IsBool {
+				spec = "--" + f.Name + "=[" + desc + "]:" + f.Name + ":"
+				if len(f.Values) > 0 {
+					spec += "(" + strings.Join(f.Values, " ") + ")"
+				}
+			}
+			specs = append(specs, shellQuote(spec))
+		}
+		return strings.Join(specs, " \\\n    ")
+	}
+
+	fmt.Fprintf(w, `#compdef atuin-fzf
+
+_atuin_fzf() {
+  case $words[2] in
+  export)
+    shift words; (( CURRENT-- ))
+    _arguments \
+    %v
+    return
+    ;;
+  inspect)
+    shift words; (( CURRENT-- ))
+    _arguments \
+    %v \
+    '1:command:'
+    return
+    ;;
+  completion)
+    _arguments '2:shell:(%v)'
+    return
+    ;;
+  esac
+
+  _arguments \
+    %v \
+    '1::subcommand:(%v)'
+}
+
+compdef _atuin_fzf atuin-fzf
+`, zshSpecs(exportFlags), zshSpecs(inspectFlags), strings.Join(_completionShells, " "), zshSpecs(mainFlags), strings.Join(_subcommands, " "))
+}
+
+func writeBashCompletion(w io.Writer, mainFlags, exportFlags, inspectFlags []completionFlag) {
+	names := func(flags []completionFlag) string {
+		var names []string
+		for _, f := range flags {
+			names = append(names, "--"+f.Name)
+		}
+		return strings.Join(names, " ")
+	}
+
+	var values strings.Builder
+	for _, f := range slices.Concat(mainFlags, exportFlags, inspectFlags) {
+		if len(f.Values) > 0 {
+			fmt.Fprintf(&values, "  --%v) COMPREPLY=($(compgen -W %v -- \"$cur\")); return ;;\n",
+				f.Name, shellQuote(strings.Join(f.Values, " ")))
+		}
+	}
+
+	fmt.Fprintf(w, `_atuin_fzf() {
+  local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+
+  case $prev in
+%v  esac
+
+  case ${COMP_WORDS[1]} in
+  export)
+    COMPREPLY=($(compgen -W %v -- "$cur"))
+    ;;
+  inspect)
+    COMPREPLY=($(compgen -W %v -- "$cur"))
+    ;;
+  completion)
+    COMPREPLY=($(compgen -W %v -- "$cur"))
+    ;;
+  *)
+    local words=%v
+    if (( COMP_CWORD == 1 )); then
+      words="%v $words"
+    fi
+    COMPREPLY=($(compgen -W "$words" -- "$cur"))
+    ;;
+  esac
+}
+
+complete -F _atuin_fzf atuin-fzf
+`, values.String(), shellQuote(names(exportFlags)), shellQuote(names(inspectFlags)), shellQuote(strings.Join(_completionShells, " ")),
+		shellQuote(names(mainFlags)), strings.Join(_subcommands, " "))
+}
+
+func writeFishCompletion(w io.Writer, mainFlags, exportFlags, inspectFlags []completionFlag) {
+	subcommands := strings.Join(_subcommands, " ")
+	fmt.Fprintf(w, "complete -c atuin-fzf -n __fish_use_subcommand -xa %v\n", shellQuote(subcommands))
+	fmt.Fprintf(w, "complete -c atuin-fzf -n '__fish_seen_subcommand_from completion' -xa %v\n",
+		shellQuote(strings.Join(_completionShells, " ")))
+
+	writeFlags := func(cond string, flags []completionFlag) {
+		for _, f := range flags {
+			line := fmt.Sprintf("complete -c atuin-fzf -n %v -l %v -d %v", shellQuote(cond), f.Name, shellQuote(f.Usage))
+			switch {
+			case len(f.Values) > 0:
+				line += " -xa " + shellQuote(strings.Join(f.Values, " "))
+			case !f.IsBool:
+				line += " -r"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+	writeFlags("not __fish_seen_subcommand_from "+subcommands, mainFlags)
+	writeFlags("__fish_seen_subcommand_from export", exportFlags)
+	writeFlags("__fish_seen_subcommand_from inspect", inspectFlags)
+}
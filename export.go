@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prashantv/atuin-fzf/atuinfzf"
+)
+
+// exportOptions are the options for the export subcommand.
+type exportOptions struct {
+	options
+
+	Format     string
+	Fields     []string
+	TimeFormat string
+}
+
+// _exportFields are the fields that can be exported, in their default order.
+var _exportFields = []string{"time", "relative_time", "duration", "exit", "directory", "command"}
+
+func parseExportOptions(args []string) (exportOptions, error) {
+	var (
+		opts   exportOptions
+		fields string
+	)
+
+	fs := newExportFlagSet(&opts, &fields)
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if fs.NArg() > 0 {
+		err := fmt.Errorf("unexpected arguments: %q", fs.Args())
+		fmt.Fprintln(fs.Output(), err)
+		return opts, err
+	}
+
+	opts.Fields = strings.Split(fields, ",")
+	if err := opts.validate(); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// newExportFlagSet returns the flag set for the export subcommand, which sets opts,
+// and fields to the comma-separated fields to export.
+func newExportFlagSet(opts *exportOptions, fields *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("atuin-fzf export", flag.ContinueOnError)
+	registerAtuinFlags(fs, &opts.options)
+	fs.StringVar(&opts.Format, "format", "json", "output format, json or csv")
+	fs.StringVar(fields, "fields", strings.Join(_exportFields, ","), "comma-separated fields to export, from: "+strings.Join(_exportFields, ", "))
+	fs.StringVar(&opts.TimeFormat, "time-format", "human", "format of time and duration: human as displayed by atuin, or raw (RFC 3339 and nanoseconds)")
+	return fs
+}
+
+func (o exportOptions) validate() error {
+	if err := o.validateAtuin(); err != nil {
+		return err
+	}
+	if o.Format != "json" && o.Format != "csv" {
+		return fmt.Errorf("invalid --format %q, expected json or csv", o.Format)
+	}
+	if o.TimeFormat != "human" && o.TimeFormat != "raw" {
+		return fmt.Errorf("invalid --time-format %q, expected human or raw", o.TimeFormat)
+	}
+	for _, f := range o.Fields {
+		if !slices.Contains(_exportFields, f) {
+			return fmt.Errorf("invalid field %q, expected one of: %v", f, strings.Join(_exportFields, ", "))
+		}
+	}
+	return nil
+}
+
+// export writes the history, as listed by the picker, to w.
+func export(opts exportOptions, w io.Writer) error {
+	results, err := atuinfzf.Stream(context.Background(), opts.Options)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	var ew exportWriter
+	switch opts.Format {
+	case "csv":
+		ew = &csvExportWriter{w: csv.NewWriter(bw), fields: opts.Fields}
+	case "json":
+		ew = &jsonExportWriter{w: bw, fields: opts.Fields}
+	}
+
+	for r, err := range results {
+		if err != nil {
+			return fmt.Errorf("read atuin history: %w", err)
+		}
+
+		values := make([]any, len(opts.Fields))
+		for i, f := range opts.Fields {
+			values[i] = opts.exportValue(r, f)
+		}
+		if err := ew.Write(values); err != nil {
+			return err
+		}
+	}
+
+	if err := ew.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+type exportWriter interface {
+	// Write writes the values for one history entry, in the order of the exported fields.
+	Write(values []any) error
+	Close() error
+}
+
+type csvExportWriter struct {
+	w      *csv.Writer
+	fields []string
+	n      int
+}
+
+func (cw *csvExportWriter) Write(values []any) error {
+	if cw.n == 0 {
+		if err := cw.w.Write(cw.fields); err != nil {
+			return err
+		}
+	}
+	cw.n++
+
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = fmt.Sprint(v)
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvExportWriter) Close() error {
+	if cw.n == 0 {
+		if err := cw.w.Write(cw.fields); err != nil {
+			return err
+		}
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// jsonExportWriter writes a JSON array of objects, with keys in the order of the fields.
+type jsonExportWriter struct {
+	w      *bufio.Writer
+	fields []string
+	n      int
+}
+
+func (jw *jsonExportWriter) Write(values []any) error {
+	sep := ",\n"
+	if jw.n == 0 {
+		sep = "[\n"
+	}
+	jw.n++
+
+	jw.w.WriteString(sep + "{")
+	for i, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		key, err := json.Marshal(jw.fields[i])
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			jw.w.WriteString(",")
+		}
+		jw.w.Write(key)
+		jw.w.WriteString(":")
+		jw.w.Write(data)
+	}
+	_, err := jw.w.WriteString("}")
+	return err
+}
+
+func (jw *jsonExportWriter) Close() error {
+	if jw.n == 0 {
+		_, err := jw.w.WriteString("[]\n")
+		return err
+	}
+	_, err := jw.w.WriteString("\n]\n")
+	return err
+}
+
+func (o exportOptions) exportValue(r atuinfzf.Entry, field string) any {
+	switch field {
+	case "time":
+		if o.TimeFormat == "raw" {
+			if t, err := atuinfzf.ParseTime(r.Time); err == nil {
+				return t.Format(time.RFC3339)
+			}
+		}
+		return r.Time
+	case "relative_time":
+		return r.Relative(time.Now())
+	case "duration":
+		if o.TimeFormat == "raw" {
+			if d, err := atuinfzf.ParseDuration(r.Duration); err == nil {
+				return int64(d)
+			}
+		}
+		return r.Duration
+	case "exit":
+		if code, err := strconv.Atoi(r.Exit); err == nil {
+			return code
+		}
+		return r.Exit
+	case "directory":
+		return r.Directory
+	case "command":
+		return r.Command
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/prashantv/atuin-fzf/atuinfzf"
+)
+
+// parseInspectOptions parses the arguments of the inspect subcommand,
+// returning the options and the command to inspect.
+func parseInspectOptions(args []string) (options, string, error) {
+	var opts options
+
+	fs := newInspectFlagSet(&opts)
+	if err := fs.Parse(args); err != nil {
+		return opts, "", err
+	}
+	if fs.NArg() != 1 {
+		err := fmt.Errorf("expected a single command to inspect, got %q", fs.Args())
+		fmt.Fprintln(fs.Output(), err)
+		return opts, "", err
+	}
+
+	if err := opts.validateAtuin(); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return opts, "", err
+	}
+	if err := opts.validatePreview(); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return opts, "", err
+	}
+
+	return opts, fs.Arg(0), nil
+}
+
+// newInspectFlagSet returns the flag set for the inspect subcommand, which sets opts.
+func newInspectFlagSet(opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet("atuin-fzf inspect", flag.ContinueOnError)
+	registerAtuinFlags(fs, opts)
+	registerPreviewFlags(fs, opts)
+	return fs
+}
+
+// inspect writes the preview of the most recent run of exactly command to w.
+func inspect(opts options, command string, w io.Writer) error {
+	runs, err := atuinfzf.CommandHistory(context.Background(), opts.Options, command, opts.Limit)
+	if err != nil {
+		return err
+	}
+
+	if len(runs) == 0 {
+		return fmt.Errorf("no runs of %q found", command)
+	}
+
+	latest := runs[0]
+	latestAt, _ := atuinfzf.ParseTime(latest.Time)
+	for _, r := range runs[1:] {
+		if t, err := atuinfzf.ParseTime(r.Time); err == nil && t.After(latestAt) {
+			latest, latestAt = r, t
+		}
+	}
+	return atuinfzf.RenderPreview(w, latest, opts.Options)
+}
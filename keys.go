@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/prashantv/atuin-fzf/atuinfzf"
+)
+
+// keyAction is an fzf bind whose key can be configured.
+type keyAction struct {
+	Name       string
+	DefaultKey string
+	Desc       string
+}
+
+// _keyActions are the configurable binds, in the order they're listed in the header.
+var _keyActions = []keyAction{
+	{"chdir", "ctrl-o", "select and chdir"},
+	{"yank", "ctrl-y", "yank"},
+	{"yank-markdown", "alt-y", "yank as Markdown"},
+	{"reload", "ctrl-l", "reload"},
+	{"favorite", "alt-s", "favorite"},
+	{"note", "alt-n", "edit note"},
+	{"search-mode", "alt-a", "toggle atuin search"},
+	{"failed", "alt-e", "toggle failed"},
+	{"tmux", "alt-w", "open in a tmux window"},
+	{"open-dir", "alt-o", "open the directory in an editor"},
+	{"toggle-preview", "ctrl-/", "toggle the preview"},
+}
+
+// _reservedKeys are keys and events that atuin-fzf binds itself, so they can't be remapped.
+var _reservedKeys = map[string]string{
+	"enter":  "selecting the command",
+	"start":  "atuin search mode",
+	"change": "atuin search mode",
+	"load":   "the match count in the header",
+}
+
+func keyActionNames() []string {
+	names := make([]string, len(_keyActions))
+	for i, a := range _keyActions {
+		names[i] = a.Name
+	}
+	return names
+}
+
+// keymap maps bind actions to fzf keys. As a flag, it's set using
+// comma-separated action=key pairs, which override the default keys.
+type keymap map[string]string
+
+func defaultKeymap() keymap {
+	km := make(keymap, len(_keyActions))
+	for _, a := range _keyActions {
+		km[a.Name] = a.DefaultKey
+	}
+	return km
+}
+
+func (km keymap) String() string {
+	var pairs []string
+	for _, name := range keyActionNames() {
+		if key, ok := km[name]; ok {
+			pairs = append(pairs, name+"="+key)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (km keymap) Set(s string) error {
+	for pair := range strings.SplitSeq(s, ",") {
+		action, key, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("expected action=key, got %q", pair)
+		}
+		if err := km.setKey(action, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (km keymap) setKey(action, key string) error {
+	if !slices.Contains(keyActionNames(), action) {
+		return fmt.Errorf("unknown action %q, expected one of: %v", action, strings.Join(keyActionNames(), ", "))
+	}
+	if key == "" {
+		return fmt.Errorf("missing key for %v", action)
+	}
+	km[action] = key
+	return nil
+}
+
+func (km keymap) validate() error {
+	actions := make(map[string]string, len(km))
+	for _, name := range keyActionNames() {
+		key := km[name]
+		if use, ok := _reservedKeys[key]; ok {
+			return fmt.Errorf("invalid --keys: %v can't be bound to %v, it's used for %v", name, key, use)
+		}
+		if other, ok := actions[key]; ok {
+			return fmt.Errorf("invalid --keys: %v and %v are both bound to %v", other, name, key)
+		}
+		actions[key] = name
+	}
+	return nil
+}
+
+// validateCustomBinds checks that the custom binds don't use reserved keys,
+// keys bound to actions, or each other.
+func (km keymap) validateCustomBinds(binds []customBind) error {
+	keys := make(map[string]string, len(_reservedKeys)+len(km)+len(binds))
+	for key, use := range _reservedKeys {
+		keys[key] = use
+	}
+	for action, key := range km {
+		keys[key] = action
+	}
+	for _, b := range binds {
+		if other, ok := keys[b.Key]; ok {
+			return fmt.Errorf("invalid --custom-bind: %v is already bound to %v", b.Key, other)
+		}
+		keys[b.Key] = "another custom bind"
+	}
+	return nil
+}
+
+// hint returns the header text describing the bind for action.
+func (km keymap) hint(action string) string {
+	for _, a := range _keyActions {
+		if a.Name == action {
+			return "[" + keyLabel(km[action]) + "] to " + a.Desc
+		}
+	}
+	panic("unknown key action " + action)
+}
+
+// keyFlag is a flag that sets the key for a single action in a keymap.
+type keyFlag struct {
+	keys   keymap
+	action string
+}
+
+func (f keyFlag) String() string {
+	return f.keys[f.action]
+}
+
+func (f keyFlag) Set(key string) error {
+	return f.keys.setKey(f.action, key)
+}
+
+// keyLabel returns a key name for display, e.g. "Ctrl-Y" for "ctrl-y".
+func keyLabel(key string) string {
+	parts := strings.Split(key, "-")
+	for i, p := range parts {
+		if p != "" {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// customBind is a user-defined bind that runs a command on the selected row.
+type customBind struct {
+	Key     string
+	Command string
+	Silent  bool
+}
+
+// _customBindFields are the placeholders in custom bind commands, and the fields they're replaced with.
+var _customBindFields = strings.NewReplacer(
+	"{command}", atuinfzf.FieldRef(atuinfzf.FieldCommand),
+	"{directory}", atuinfzf.FieldRef(atuinfzf.FieldDirectory),
+	"{exit}", atuinfzf.FieldRef(atuinfzf.FieldExit),
+)
+
+// fzfAction returns the fzf action for b. The command is the rest of the action,
+// so it may contain any characters, including parentheses.
+func (b customBind) fzfAction() string {
+	action := "execute"
+	if b.Silent {
+		action = "execute-silent"
+	}
+	return action + ":" + _customBindFields.Replace(b.Command)
+}
+
+// customBindFlag is a repeatable flag that adds custom binds set using key=command.
+// Binds are separated by newlines in String, so the flag can be propagated.
+type customBindFlag struct {
+	binds  *[]customBind
+	silent bool
+}
+
+func (f customBindFlag) String() string {
+	if f.binds == nil {
+		return ""
+	}
+
+	var binds []string
+	for _, b := range *f.binds {
+		if b.Silent == f.silent {
+			binds = append(binds, b.Key+"="+b.Command)
+		}
+	}
+	return strings.Join(binds, "\n")
+}
+
+func (f customBindFlag) Set(s string) error {
+	for bind := range strings.SplitSeq(s, "\n") {
+		key, command, ok := strings.Cut(bind, "=")
+		if !ok || key == "" || command == "" {
+			return fmt.Errorf("expected key=command, got %q", bind)
+		}
+		*f.binds = append(*f.binds, customBind{Key: key, Command: command, Silent: f.silent})
+	}
+	return nil
+}
@@ -1,264 +1,882 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"iter"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/prashantv/atuin-fzf/atuinfzf"
 	"github.com/prashantv/atuin-fzf/tcolor"
 )
 
-const _delim = "\t:::\t"
+// _subcommandMains run each subcommand, given the arguments after its name.
+// Other arguments are the picker's flags and query.
+var _subcommandMains = map[string]func(args []string){
+	"export":     exportMain,
+	"inspect":    inspectMain,
+	"completion": completionMain,
+}
 
 func main() {
 	if len(os.Args) > 1 {
-		switch os.Args[1] {
-		case "--preview":
-			if err := fzfPreview(os.Args[2]); err != nil {
-				log.Fatal(err)
-			}
+		if run, ok := _subcommandMains[os.Args[1]]; ok {
+			run(os.Args[2:])
 			return
-		case "--zsh":
-			exe, err := os.Executable()
-			if err != nil {
-				exe = os.Args[0]
+		}
+	}
+
+	os.Exit(pickerMain(os.Args[1:]))
+}
+
+// pickerMain runs the picker, or one of its internal modes, returning the exit code.
+// It returns rather than exiting, so deferred cleanup, such as stopping profiles, runs.
+func pickerMain(args []string) int {
+	opts, args, err := parseOptions(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+
+	if err := tcolor.SetTheme(opts.Theme); err != nil {
+		log.Print(err)
+		return 1
+	}
+	tcolor.SetEnabled(os.Getenv("NO_COLOR") == "")
+
+	if opts.Profile != "" || opts.Trace != "" {
+		stop, err := startProfile(opts.Profile, opts.Trace)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		defer func() {
+			if err := stop(); err != nil {
+				log.Print(err)
 			}
-			fmt.Printf(_zshFn, exe)
-			return
+		}()
+	}
+
+	if opts.Zsh {
+		exe, err := os.Executable()
+		if err != nil {
+			exe = os.Args[0]
+		}
+		selfCmd := shellJoin(append([]string{exe}, opts.selfArgs()...))
+		if opts.ExcludeBuffer {
+			selfCmd += ` --exclude-command "$BUFFER"`
 		}
+		fmt.Printf(_zshFn, selfCmd)
+		return 0
 	}
-	var initialQuery string
-	if len(os.Args) > 1 {
-		initialQuery = os.Args[1]
+
+	if opts.isSet("toggle-favorite") {
+		if _, err := atuinfzf.ToggleFavorite(opts.ToggleFavorite); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	if opts.isSet("yank") {
+		if err := yank(opts.Yank, opts.Yank, opts.NoConfirm); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
 	}
 
-	if err := run(initialQuery); err != nil {
+	if opts.isSet("yank-markdown") {
+		row, err := decodeRowData(opts.YankMarkdown)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		e := row.Entry()
+		if err := yank(e.Command, markdownText(opts.MarkdownFmt, e), opts.NoConfirm); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	if opts.isSet("tmux-window") {
+		row, err := decodeRowData(opts.TmuxWindow)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		if err := tmuxWindow(row.Entry(), opts.TmuxRun, opts.NoConfirm); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	if opts.isSet("edit-note") {
+		if err := editNote(opts.EditNote); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	if opts.isSet("open-dir") {
+		if err := openDir(opts.OpenDir, opts.DirEditor); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	// The plain preview doesn't run atuin, so it's rendered even if atuin can't be found.
+	if !opts.isSet("preview") || !opts.PlainPreview {
+		if err := resolveBin("atuin", &opts.AtuinBin); err != nil {
+			log.Print(err)
+			return 1
+		}
+	}
+
+	opts.resolveSinceBoot()
+	opts.resolveMetaColumn()
+	opts.inheritAtuinConfig()
+
+	// Arguments are joined, so `atuin-fzf git push` queries "git push".
+	// Internal modes such as --preview take their input as flag values, not arguments.
+	query := strings.Join(args, " ")
+
+	if opts.List {
+		opts.resolveHideFailed()
+		opts.resolveProgram()
+		opts.resolveDir()
+		// The query is used by atuin's search mode to filter the history.
+		opts.Query = query
+		opts.resolveDirAlias()
+		if err := list(opts, os.Stdout); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	if opts.isSet("preview") {
+		if err := fzfPreview(opts, opts.Preview); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	if err := opts.resolveFinder(); err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	if err := run(opts, query); err != nil {
+		if errors.Is(err, errNoSelection) {
+			return _exitNoSelection
+		}
+
+		log.Print(err)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		return 1
+	}
+	return 0
+}
+
+func exportMain(args []string) {
+	opts, err := parseExportOptions(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	opts.inheritAtuinConfig()
+	if err := resolveBin("atuin", &opts.AtuinBin); err != nil {
+		log.Fatal(err)
+	}
+	if err := export(opts, os.Stdout); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run(query string) error {
-	globalResults, err := runAtuin(atuinParams{
-		Limit: 1000,
-	})
+func inspectMain(args []string) {
+	opts, command, err := parseInspectOptions(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		return err
+		os.Exit(2)
+	}
+	if err := tcolor.SetTheme(opts.Theme); err != nil {
+		log.Fatal(err)
+	}
+	tcolor.SetEnabled(os.Getenv("NO_COLOR") == "")
+	opts.inheritAtuinConfig()
+	if err := resolveBin("atuin", &opts.AtuinBin); err != nil {
+		log.Fatal(err)
+	}
+	if err := inspect(opts, command, os.Stdout); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	sessionResults, err := runAtuin(atuinParams{
-		Limit:      1000,
-		FilterMode: "session",
-	})
+func completionMain(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: atuin-fzf completion <%v>\n", strings.Join(_completionShells, "|"))
+		os.Exit(2)
+	}
+	if err := completion(args[0], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// _exitNoSelection is the exit code when fzf exits without a selection,
+// distinct from errors (1), invalid usage (2), and errors from fzf (passed through).
+const _exitNoSelection = 3
+
+// errNoSelection is returned when fzf exits without a selection.
+var errNoSelection = errors.New("nothing selected")
+
+func run(opts options, query string) error {
+	if opts.RememberQuery && query == "" {
+		query, _ = atuinfzf.LastQuery(opts.queryScope()) // best effort
+	}
+
+	stateDir, err := newStateDir(opts)
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(stateDir)
+	opts.StateDir = stateDir
 
-	results := mergeRight(globalResults, sessionResults)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results, err := atuinfzf.History(ctx, opts.Options)
+	if err != nil {
+		return err
+	}
 
-	fzfInput, err := atuinToFzf(results)
+	fzfInput, waitRows, err := atuinToFzf(ctx, opts, results)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		// fzf may exit before all rows are written, so stop atuin and writing rows,
+		// which is unblocked by closing the pipe, so neither outlives run.
+		fzfInput.Close()
+		cancel()
+		waitRows()
+	}()
+
+	output := os.Stdout
+	if opts.OutputFD != 1 {
+		output = os.NewFile(uintptr(opts.OutputFD), "output-fd")
+		if _, err := output.Stat(); err != nil {
+			return fmt.Errorf("invalid --output-fd %v: %w", opts.OutputFD, err)
+		}
+		defer output.Close()
+	}
+
+	finder := fzf
+	if opts.Finder == "sk" {
+		finder = sk
+	}
+
+	if opts.AppendSpace || opts.StripPrefix != "" || opts.RememberQuery {
+		var out bytes.Buffer
+		err := finder(opts, fzfInput, query, &out)
+
+		selection := out.String()
+		if opts.RememberQuery {
+			// With --print-query, fzf prints the query before the selection,
+			// including when there's no match, but not when it's aborted.
+			var printed bool
+			query, selection, printed = strings.Cut(selection, "\n")
+			if printed {
+				if err := atuinfzf.SaveLastQuery(opts.queryScope(), query); err != nil {
+					log.Printf("failed to save query: %v", err)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(output, formatSelection(opts, selection))
+		return err
+	}
 
-	if err := fzf(fzfInput, query); err != nil {
+	if err := finder(opts, fzfInput, query, output); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func atuinToFzf(results iter.Seq[atuinResult]) (io.Reader, error) {
-	r, w, err := os.Pipe()
-	if err != nil {
-		return nil, err
+// _chdirPrefix prefixes the directory and command written by the chdir bind.
+const _chdirPrefix = "CHDIR:\t"
+
+// formatSelection applies --strip-prefix and --append-space to the command selected in fzf.
+func formatSelection(opts options, selection string) string {
+	var prefix string
+	selection, newline := strings.CutSuffix(selection, "\n")
+	if rest, ok := strings.CutPrefix(selection, _chdirPrefix); ok {
+		dir, command, _ := strings.Cut(rest, "\t")
+		prefix, selection = _chdirPrefix+dir+"\t", command
+	} else if opts.EmitDirCommand {
+		// The output isn't newline-terminated, so a trailing newline is part of the command.
+		if newline {
+			selection += "\n"
+			newline = false
+		}
+		dir, command, _ := strings.Cut(selection, "\x00")
+		prefix, selection = dir+"\x00", command
 	}
 
-	curDir, _ := os.Getwd() // best effort
-	go func() {
-		for r := range results {
-			if r.Error != nil {
-				// FIXME
-				panic(err)
-			}
+	// The pattern is checked by validate.
+	if re, _ := opts.stripPrefix(); re != nil {
+		selection = re.ReplaceAllString(selection, "")
+	}
+	if opts.AppendSpace {
+		selection += " "
+	}
+	if newline {
+		selection += "\n"
+	}
+	return prefix + selection
+}
 
-			dirCtx := ""
-			if r.Directory == curDir {
-				dirCtx = tcolor.Gray.Foreground("(same cwd)")
-			}
+// list writes the fzf rows to w, used to reload the list from fzf.
+func list(opts options, w io.Writer) error {
+	results, err := atuinfzf.History(context.Background(), opts.Options)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeRows(bw, results, opts); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
 
-			_, err := fmt.Fprint(w, strings.Join([]string{
-				r.Command,
-				r.Exit,
-				r.Directory,
-				r.Duration,
-				r.Time,
-				r.RelativeTime,
-				exitColor(r.Exit),
-				dirCtx,
-				string(byte(0)),
-			}, _delim))
-			if err != nil {
-				// FIXME
-				panic(err)
+// writeRows writes the fzf rows for results to w, noting in the state dir
+// whether the history may have been capped by --limit.
+func writeRows(w io.Writer, results iter.Seq[atuinfzf.Entry], opts options) error {
+	var n int
+	counted := func(yield func(atuinfzf.Entry) bool) {
+		for e := range results {
+			n++
+			if !yield(e) {
+				return
 			}
 		}
+	}
+	if err := atuinfzf.WriteRows(w, counted, opts.Options); err != nil {
+		return err
+	}
 
-		if err := w.Close(); err != nil {
-			// FIXME
-			panic(err)
-		}
-	}()
-	return r, nil
+	// Rows may be fewer than the entries loaded, e.g. with --dedup, so the entries are counted.
+	return opts.setState(_limitedState, n >= opts.Limit)
 }
 
-func fzf(input io.Reader, query string) error {
-	selfExe, err := os.Executable()
+// atuinToFzf returns a pipe that the fzf rows for results are written to,
+// and a function that waits until writing rows has stopped.
+// Closing the pipe, or canceling ctx, stops writing rows.
+func atuinToFzf(ctx context.Context, opts options, results iter.Seq[atuinfzf.Entry]) (*os.File, func(), error) {
+	r, w, err := os.Pipe()
 	if err != nil {
-		return fmt.Errorf("self executable: %w", err)
+		return nil, nil, err
 	}
 
-	previewCmd := fmt.Sprintf("%s --preview {}", selfExe)
-	fzfCmd := exec.Command(
-		"fzf",
-		"--read0",
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer w.Close()
+
+		// fzf closes the pipe once a selection is made, so EPIPE is expected,
+		// as are errors from atuin once ctx is canceled.
+		if err := writeRows(w, results, opts); err != nil && !errors.Is(err, syscall.EPIPE) && ctx.Err() == nil {
+			log.Print(err)
+		}
+	}()
+	return r, func() { <-done }, nil
+}
+
+func fzf(opts options, input io.Reader, query string, output io.Writer) error {
+	// fzf applies FZF_DEFAULT_OPTS before its arguments, so the user's options
+	// apply unless set below. Essential flags for parsing rows are set last.
+	fzfArgs := []string{
 		"--tac",
-		"--ansi",
-		"--scheme", "history",
+		"--scheme", opts.Scheme,
 		"--prompt", "> ",
-		"--header", "[Enter] to select, [Ctrl-O] to select and chdir, [Ctrl-Y] to yank.",
-		"--preview", previewCmd,
 		"--preview-window", "right:40%:wrap,<50(hidden)",
-		"--delimiter", _delim,
-		"--with-nth", "{1}  {7} {8}",
-		"--accept-nth", "{1}",
-		"--bind", "ctrl-y:execute-silent(echo -n {1} | pbcopy)+abort",
-		"--bind", "ctrl-o:become(printf \"CHDIR:\\t%s\\t%s\" {3} {1})",
-		"--query", query,
 		"--height", "80%",
+		// Align the command after the status badge, which is separated by a tab.
+		"--tabstop", "4",
+		"--query", query,
+	}
+	chdirAction := chdirAction(opts)
+	fzfArgs = append(fzfArgs, opts.rowBind(opts.Keys["chdir"], chdirAction)...)
+	acceptAction := "accept"
+	if opts.EmitDirCommand {
+		acceptAction = emitDirCommandAction(opts)
+		fzfArgs = append(fzfArgs, "--bind", "enter:"+acceptAction)
+	}
+	if opts.Tiebreak != "" {
+		fzfArgs = append(fzfArgs, "--tiebreak", opts.Tiebreak)
+	}
+	if opts.Order == "newest-first" {
+		// Rows are always reversed with --tac so the newest entries rank first,
+		// so show the list top-down rather than dropping --tac.
+		fzfArgs = append(fzfArgs, "--layout", "reverse")
+	}
+
+	// enterCmd, if set, is a command for Enter's transform action.
+	var enterCmd string
+	hints := []string{"[Enter] to select", opts.Keys.hint("chdir")}
+	previewCmd := inlinePreviewCmd()
+	if selfExe, err := selfExecutable(); err != nil {
+		// Without a reliable self-exec, fall back to a preview using only fzf's placeholders.
+		log.Printf("using basic preview: %v", err)
+
+		// The yank bind is omitted rather than silently failing without a clipboard command.
+		if clipCmd := clipboardCmd(); clipCmd != nil {
+			fzfArgs = append(fzfArgs, opts.rowBind(opts.Keys["yank"], "execute-silent(printf %s "+atuinfzf.FieldRef(atuinfzf.FieldCommand)+" | "+shellJoin(clipCmd)+")+abort")...)
+			hints = append(hints, opts.Keys.hint("yank"))
+		}
+	} else {
+		selfCmd := shellJoin(append(append([]string{selfExe}, opts.selfArgs()...), "--state-dir", opts.StateDir))
+		previewCmd = selfCmd + " --preview " + rowDataRef()
+		reload := "reload(" + selfCmd + " --list)"
+		if canYank() {
+			// Yank via execute rather than execute-silent, so it can prompt for confirmation.
+			fzfArgs = append(fzfArgs, opts.rowBind(opts.Keys["yank"], "execute("+selfCmd+" --yank "+atuinfzf.FieldRef(atuinfzf.FieldCommand)+")+abort")...)
+			fzfArgs = append(fzfArgs, opts.rowBind(opts.Keys["yank-markdown"], "execute("+selfCmd+" --yank-markdown "+rowDataRef()+")+abort")...)
+			hints = append(hints, opts.Keys.hint("yank"), opts.Keys.hint("yank-markdown"))
+		}
+		fzfArgs = append(fzfArgs,
+			"--bind", opts.Keys["reload"]+":"+reload,
+			"--bind", opts.Keys["failed"]+":execute-silent("+toggleFileCmd(opts.statePath(_hideFailedState))+")+"+reload,
+		)
+		fzfArgs = append(fzfArgs, opts.rowBind(opts.Keys["favorite"], "execute-silent("+selfCmd+" --toggle-favorite "+atuinfzf.FieldRef(atuinfzf.FieldCommand)+")+"+reload)...)
+		fzfArgs = append(fzfArgs, opts.rowBind(opts.Keys["note"], "execute("+selfCmd+" --edit-note "+atuinfzf.FieldRef(atuinfzf.FieldCommand)+")+refresh-preview")...)
+		fzfArgs = append(fzfArgs, opts.rowBind(opts.Keys["open-dir"], "execute("+selfCmd+" --open-dir "+atuinfzf.FieldRef(atuinfzf.FieldDirectory)+")")...)
+		fzfArgs = append(fzfArgs, searchModeBinds(selfCmd, opts.Keys["search-mode"])...)
+		switch {
+		case opts.CommandsOnly:
+			fzfArgs = append(fzfArgs, "--prompt", _programPrompt)
+			enterCmd = selectRunsCmd(selfCmd, acceptAction, opts.statePath(_programState))
+		case opts.ByDir:
+			fzfArgs = append(fzfArgs, "--prompt", _dirPrompt)
+			enterCmd = selectRunsCmd(selfCmd, acceptAction, opts.statePath(_dirState))
+		}
+		hints = append(hints,
+			opts.Keys.hint("reload"),
+			opts.Keys.hint("favorite"),
+			opts.Keys.hint("note"),
+			opts.Keys.hint("open-dir"),
+			opts.Keys.hint("search-mode"),
+			opts.Keys.hint("failed"),
+		)
+		if inTmux() {
+			// Executed rather than silent, so it can prompt for confirmation.
+			fzfArgs = append(fzfArgs, opts.rowBind(opts.Keys["tmux"], "execute("+selfCmd+" --tmux-window "+rowDataRef()+")+abort")...)
+			hints = append(hints, opts.Keys.hint("tmux"))
+		}
+	}
+	fzfArgs = append(fzfArgs, "--bind", opts.Keys["toggle-preview"]+":toggle-preview")
+	hints = append(hints, opts.Keys.hint("toggle-preview"))
+	if opts.writesSeparators() {
+		// Separator rows can't be selected.
+		if enterCmd == "" {
+			enterCmd = printActionCmd(acceptAction)
+		}
+		enterCmd = skipSeparatorCmd(enterCmd)
+	}
+	if enterCmd != "" {
+		fzfArgs = append(fzfArgs, "--bind", "enter:transform:"+enterCmd)
+	}
+	for _, b := range opts.CustomBinds {
+		fzfArgs = append(fzfArgs, opts.rowBind(b.Key, b.fzfAction())...)
+	}
+	header := strings.Join(hints, ", ")
+	fzfArgs = append(fzfArgs,
+		"--header", header+".",
+		"--bind", "load:transform-header:"+countHeaderCmd(header, opts),
+	)
+
+	fzfArgs = append(fzfArgs, opts.FzfArgs...)
+
+	// Essential flags, which must match the rows and subcommands.
+	fzfArgs = append(fzfArgs,
+		"--read0",
+		"--ansi",
+		"--delimiter", atuinfzf.Delim+"|"+_displaySep+"|"+_prefixSep,
+		"--accept-nth", atuinfzf.FieldRef(atuinfzf.FieldCommand),
+		"--preview", previewCmd,
 	)
+	if opts.RememberQuery {
+		fzfArgs = append(fzfArgs, "--print-query")
+	}
+	if opts.ShowDelim {
+		fzfArgs = append(fzfArgs, "--nth", strconv.Itoa(atuinfzf.FieldDisplay))
+	} else {
+		// fzf applies --nth to the displayed text, so only the command and
+		// any --search-fields are matched, not the colored markers.
+		template, nth := withNth(opts)
+		fzfArgs = append(fzfArgs, "--with-nth", template, "--nth", nth)
+	}
 
+	fzfCmd := exec.Command(opts.FzfBin, fzfArgs...)
+
+	if opts.NoDefaultOpts {
+		fzfCmd.Env = append(os.Environ(), "FZF_DEFAULT_OPTS=", "FZF_DEFAULT_OPTS_FILE=")
+	}
 	fzfCmd.Stdin = input
 	fzfCmd.Stderr = os.Stderr
-	fzfCmd.Stdout = os.Stdout
+	fzfCmd.Stdout = output
 
-	if err := fzfCmd.Run(); err != nil {
-		if err, ok := err.(*exec.ExitError); ok && err.ExitCode() == 130 {
-			// User-interrupted.
-			return nil
-		}
+	return finderError("fzf", fzfCmd.Run())
+}
+
+// finderError returns the error for running the finder name (fzf or sk),
+// distinguishing a finder that couldn't be started from one that failed.
+// Exiting without a selection returns errNoSelection.
+func finderError(name string, err error) error {
+	if err == nil {
+		return nil
+	}
 
-		return fmt.Errorf("run fzf: %w", err)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return fmt.Errorf("start %v, check it's installed or set --%v-bin or %v_BIN: %w",
+			name, name, strings.ToUpper(name), err)
 	}
 
-	return nil
+	switch exitErr.ExitCode() {
+	case 1, 130:
+		// No match, or user-interrupted (Esc or Ctrl-C).
+		return errNoSelection
+	}
+	return fmt.Errorf("%v failed, see its output above: %w", name, err)
 }
 
-func fzfPreview(data string) error {
-	parts := strings.Split(data, _delim)
-	if len(parts) < 6 {
-		return fmt.Errorf("data format incorrect, expected 5 parts, got %d in %s", len(parts), data)
-	}
-	command, exitCode, directory, duration, timestamp, relTimestamp := parts[0], parts[1], parts[2], parts[3], parts[4], parts[5]
-
-	exitCol := tcolor.Green
-	if exitCode != "0" {
-		exitCol = tcolor.Red
-	}
-
-	fmt.Println(tcolor.Bold("Command"))
-	fmt.Println("────────────────────────")
-	fmt.Println(command)
-	fmt.Println()
-	fmt.Println(tcolor.Bold("Execution Details"))
-	fmt.Println("────────────────────────")
-	fmt.Printf("%-10s %s %s\n", "When:", timestamp, tcolor.Cyan.Foreground(relTimestamp+" ago"))
-	fmt.Printf("%-10s %s\n", "Directory:", shortenHome(directory))
-	fmt.Printf("%-10s %s\n", "Exit Code:", exitCol.Foreground(exitCode))
-	fmt.Printf("%-10s %s\n", "Duration:", duration)
-	fmt.Println()
-	fmt.Println(tcolor.Bold("Recent Similar Commands"))
-	fmt.Println("────────────────────────")
-
-	seen := make(map[atuinResult]bool)
-	printResults := func(addArgs ...string) error {
-		results, err := runAtuin(atuinParams{
-			Query:          command,
-			Limit:          5,
-			AdditionalArgs: addArgs,
-		})
-		if err != nil {
-			return err
-		}
+// _atuinSearchPrompt is the prompt in atuin's search mode, which is used
+// to track the current search mode.
+const _atuinSearchPrompt = "atuin> "
+
+// searchModeBinds returns binds to toggle between fzf filtering the loaded rows,
+// and atuin searching the history as the query changes, with fzf only displaying the results.
+func searchModeBinds(selfCmd, key string) []string {
+	// Placeholders are escaped so they're expanded when the action runs, rather than by transform.
+	fzfMode := "change-prompt(> )+enable-search+unbind(change)+reload(" + selfCmd + " --list)"
+	atuinMode := "change-prompt(" + _atuinSearchPrompt + ")+disable-search+rebind(change)+reload(" + selfCmd + " --list -- \\{q})"
+	toggle := fmt.Sprintf(`if [ "$FZF_PROMPT" = %v ]; then printf %%s %v; else printf %%s %v; fi`,
+		shellQuote(_atuinSearchPrompt), shellQuote(fzfMode), shellQuote(atuinMode))
+
+	return []string{
+		"--bind", "start:unbind(change)",
+		"--bind", "change:reload(" + selfCmd + " --list -- {q})",
+		"--bind", key + ":transform:" + toggle,
+	}
+}
 
-		for r := range results {
-			if !seen[r] {
-				seen[r] = true
-				fmt.Printf("%s %s %s\n%s\n",
-					tcolor.Cyan.Foreground(r.RelativeTime),
-					tcolor.Gray.Foreground(shortenHome(r.Directory)),
-					exitColor(r.Exit),
-					tcolor.Bold("$ ")+r.Command,
-				)
-			}
-		}
-		return nil
+const (
+	// _programPrompt is the prompt while selecting a program with --commands-only.
+	_programPrompt = "program> "
+
+	// _dirPrompt is the prompt while selecting a directory with --by-dir.
+	_dirPrompt = "dir> "
+)
+
+// selectRunsCmd returns a command for fzf's transform action that, while selecting a program
+// with --commands-only or a directory with --by-dir, saves the selection to stateFile and lists
+// the matching commands, and otherwise runs accept.
+func selectRunsCmd(selfCmd, accept, stateFile string) string {
+	listRuns := "reload(" + selfCmd + " --list)+clear-query+change-prompt(> )"
+	return fmt.Sprintf(`if [ -s %[1]v ]; then printf %%s %[4]v; else printf %%s %[2]v > %[1]v; printf %%s %[3]v; fi`,
+		shellQuote(stateFile), atuinfzf.FieldRef(atuinfzf.FieldCommand), shellQuote(listRuns), shellQuote(accept))
+}
+
+// printActionCmd returns a command for fzf's transform action that runs action.
+// Placeholders are escaped so they're expanded when the action runs, rather than by transform.
+func printActionCmd(action string) string {
+	return "printf %s " + shellQuote(strings.ReplaceAll(action, "{", `\{`))
+}
+
+// skipSeparatorCmd returns a command for fzf's transform action that runs cmd,
+// unless the current row is a separator, which has no command.
+func skipSeparatorCmd(cmd string) string {
+	return "[ -z " + atuinfzf.FieldRef(atuinfzf.FieldCommand) + " ] || " + cmd
+}
+
+// writesSeparators returns whether the list may have separator rows, or a hint for
+// an unknown directory alias, which have no command.
+func (o options) writesSeparators() bool {
+	return o.GroupByTime || o.GroupBySession || len(o.DirAliases) > 0
+}
+
+// rowBind returns the fzf args to bind key to an action on the current row,
+// which is skipped on separator rows if the list may have them.
+func (o options) rowBind(key, action string) []string {
+	if o.writesSeparators() {
+		action = "transform:" + skipSeparatorCmd(printActionCmd(action))
 	}
+	return []string{"--bind", key + ":" + action}
+}
 
-	err := errors.Join(
-		printResults(),
-		printResults("--cwd", directory),
-	)
-	return err
+// chdirAction returns the fzf action for the chdir bind, which prints the directory
+// and command after _chdirPrefix, after the query with --remember-query.
+func chdirAction(opts options) string {
+	format, args := `CHDIR:\t%s\t%s`, atuinfzf.FieldRef(atuinfzf.FieldDirectory)+" "+atuinfzf.FieldRef(atuinfzf.FieldCommand)
+	if opts.RememberQuery {
+		format, args = `%s\n`+format, "{q} "+args
+	}
+	return "become(printf " + shellQuote(format) + " " + args + ")"
 }
 
-func exitColor(exitCode string) string {
-	if exitCode != "0" {
-		return tcolor.Red.Foreground("exit " + exitCode)
+// emitDirCommandAction returns the fzf action for --emit-dir-command, which prints the
+// directory and command separated by a null byte, after the query with --remember-query.
+func emitDirCommandAction(opts options) string {
+	format, args := `%s\0%s`, atuinfzf.FieldRef(atuinfzf.FieldDirectory)+" "+atuinfzf.FieldRef(atuinfzf.FieldCommand)
+	if opts.RememberQuery {
+		format, args = `%s\n`+format, "{q} "+args
 	}
-	return ""
+	return "become(printf " + shellQuote(format) + " " + args + ")"
 }
 
-func shortenHome(s string) string {
-	homeDir, err := os.UserHomeDir()
-	if err == nil {
-		if suffix, ok := strings.CutPrefix(s, homeDir); ok {
-			return filepath.Join("~", suffix)
-		}
+// countHeaderCmd returns a command for fzf's load event that prints the header
+// with the number of rows loaded, noting when --limit may have capped them,
+// and whether failed commands are hidden.
+func countHeaderCmd(header string, opts options) string {
+	return fmt.Sprintf(`h=%[1]v; if [ -e %[2]v ]; then h="$h. Failed commands hidden"; fi; `+
+		`if [ -e %[3]v ]; then `+
+		`printf '%%s. %%s shown, more may exist, raise --limit to load more.\n' "$h" "$FZF_TOTAL_COUNT"; `+
+		`else printf '%%s. %%s loaded.\n' "$h" "$FZF_TOTAL_COUNT"; fi`,
+		shellQuote(header), shellQuote(opts.statePath(_hideFailedState)), shellQuote(opts.statePath(_limitedState)))
+}
+
+// toggleFileCmd returns a command that creates path if it doesn't exist, and removes it otherwise.
+func toggleFileCmd(path string) string {
+	return fmt.Sprintf(`if [ -e %[1]v ]; then rm -f %[1]v; else : > %[1]v; fi`, shellQuote(path))
+}
+
+// selfExecutable returns the resolved path of the running executable,
+// verifying it can be executed by fzf for the preview and reload.
+func selfExecutable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("self executable: %w", err)
 	}
 
-	return s
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return "", fmt.Errorf("resolve self executable: %w", err)
+	}
+
+	info, err := os.Stat(exe)
+	if err != nil {
+		return "", fmt.Errorf("self executable: %w", err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+		return "", fmt.Errorf("self executable %v is not an executable file", exe)
+	}
+	return exe, nil
 }
 
-// mergeRight merges results sequences, preferring results on the right.
-func mergeRight(res1, res2 iter.Seq[atuinResult]) iter.Seq[atuinResult] {
-	var res2Vals []atuinResult
-	seen := make(map[atuinResult]struct{})
-	for r := range res2 {
-		seen[r] = struct{}{}
-		res2Vals = append(res2Vals, r)
+// inlinePreviewCmd returns a preview command that only uses fzf's placeholders.
+func inlinePreviewCmd() string {
+	return fmt.Sprintf(`printf '%%s\n\n%%-10s %%s\n%%-10s %%s\n%%-10s %%s\n%%-10s %%s\n' %v When: %v Directory: %v 'Exit Code:' %v Duration: %v`,
+		atuinfzf.FieldRef(atuinfzf.FieldCommand), atuinfzf.FieldRef(atuinfzf.FieldTime), atuinfzf.FieldRef(atuinfzf.FieldDirectory), atuinfzf.FieldRef(atuinfzf.FieldExit), atuinfzf.FieldRef(atuinfzf.FieldDuration))
+}
+
+// rowDataRef returns the shell argument for the focused row passed to subcommands,
+// prefixed by the row format, so mismatched versions are detected.
+func rowDataRef() string {
+	return shellQuote(atuinfzf.RowFormat+atuinfzf.Delim) + "{}"
+}
+
+// decodeRowData decodes a row passed by rowDataRef.
+func decodeRowData(data string) (atuinfzf.Row, error) {
+	format, row, _ := strings.Cut(data, atuinfzf.Delim)
+	if format != atuinfzf.RowFormat {
+		return atuinfzf.Row{}, fmt.Errorf("preview/parent version mismatch: got row format %q, expected %q, restart atuin-fzf", format, atuinfzf.RowFormat)
 	}
+	return atuinfzf.DecodeRow(row)
+}
 
-	return func(yield func(atuinResult) bool) {
-		for r := range res1 {
-			if _, ok := seen[r]; ok {
-				continue
-			}
+// _previewDebounce delays the preview, so quickly moving through the list
+// doesn't start searches for previews that fzf will cancel.
+const _previewDebounce = 50 * time.Millisecond
 
-			if !yield(r) {
-				return
-			}
-		}
+func fzfPreview(opts options, data string) error {
+	fzfRow, err := decodeRowData(data)
+	if err != nil {
+		return err
+	}
+	if fzfRow.IsSeparator() {
+		return nil
+	}
 
-		for _, r := range res2Vals {
-			if !yield(r) {
-				return
-			}
+	// fzf terminates the preview when the selection changes.
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(_previewDebounce):
+	}
+
+	if opts.SimilarTruncate && opts.SimilarWidth == 0 {
+		// Without the preview's width, commands are wrapped.
+		opts.SimilarWidth, _ = strconv.Atoi(os.Getenv("FZF_PREVIEW_COLUMNS"))
+	}
+
+	return atuinfzf.RenderPreviewWith(ctx, os.Stdout, fzfRow.Entry(), opts.Options, atuinfzf.AtuinSearcher(opts.Options))
+}
+
+// editNote edits the note for command in $EDITOR, removing it if left empty.
+func editNote(command string) error {
+	notes, err := atuinfzf.LoadNotes()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp("", "atuin-fzf-note-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.WriteString(f, notes[command])
+	if err := errors.Join(err, f.Close()); err != nil {
+		return err
+	}
+
+	editorCmd := exec.Command("sh", "-c", envOr("EDITOR", "vi")+` "$1"`, "sh", f.Name())
+	editorCmd.Stdin = os.Stdin
+	editorCmd.Stdout = os.Stdout
+	editorCmd.Stderr = os.Stderr
+	if err := editorCmd.Run(); err != nil {
+		return fmt.Errorf("run editor: %w", err)
+	}
+
+	note, err := os.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+	return atuinfzf.SetNote(command, strings.TrimSpace(string(note)))
+}
+
+// openDir opens dir using the editor command, or $VISUAL or $EDITOR if empty.
+// If dir no longer exists, a message is shown instead.
+func openDir(dir, editor string) error {
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		pause(fmt.Sprintf("Directory %v no longer exists.", dir))
+		return nil
+	}
+
+	if editor == "" {
+		editor = envOr("VISUAL", envOr("EDITOR", "vi"))
+	}
+	editorCmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", dir)
+	editorCmd.Stdin = os.Stdin
+	editorCmd.Stdout = os.Stdout
+	editorCmd.Stderr = os.Stderr
+	if err := editorCmd.Run(); err != nil {
+		return fmt.Errorf("run editor: %w", err)
+	}
+	return nil
+}
+
+// _displaySep separates the parts of the displayed text, which fzf splits into fields
+// for --nth along with the row's fields, so matching can be limited to parts of the display.
+const _displaySep = "\t"
+
+// _formatPresets are the templates for --format-preset. The command is followed by
+// _displaySep, so queries only match the command, as with other templates.
+var _formatPresets = map[string]string{
+	"command":         atuinfzf.FieldRef(atuinfzf.FieldDisplay),
+	"time":            atuinfzf.FieldRef(atuinfzf.FieldDisplay) + _displaySep + atuinfzf.FieldRef(atuinfzf.FieldRelativeTime),
+	"dir-time":        atuinfzf.FieldRef(atuinfzf.FieldDisplay) + _displaySep + atuinfzf.FieldRef(atuinfzf.FieldDisplayDirectory) + "  " + atuinfzf.FieldRef(atuinfzf.FieldRelativeTime),
+	"status-dir-time": atuinfzf.FieldRef(atuinfzf.FieldDisplay) + _displaySep + atuinfzf.FieldRef(atuinfzf.FieldExitStatus) + " " + atuinfzf.FieldRef(atuinfzf.FieldDisplayDirectory) + "  " + atuinfzf.FieldRef(atuinfzf.FieldRelativeTime),
+}
+
+// _prefixSep separates the command prefix from the rest of the command for --ignore-prefixes.
+// It's a zero-width space, so the command is displayed unchanged.
+const _prefixSep = "\u200b"
+
+// _searchFields are the fields that --search-fields can add to the matched text.
+var _searchFields = map[string]int{
+	"directory":     atuinfzf.FieldDirectory,
+	"time":          atuinfzf.FieldTime,
+	"relative_time": atuinfzf.FieldRelativeTime,
+	"duration":      atuinfzf.FieldDuration,
+	"exit":          atuinfzf.FieldExit,
+}
+
+// withNth returns the template for the fields displayed in fzf,
+// and the fields of the displayed text to match against.
+func withNth(opts options) (template, nth string) {
+	if opts.Template != "" {
+		return opts.Template, "1"
+	}
+
+	var (
+		sb      strings.Builder
+		fields  int // number of fzf fields in the displayed text so far.
+		matched []string
+	)
+	// addField adds a field of the displayed text, ending with sep so fzf can split the fields.
+	addField := func(ref, sep string, match bool) {
+		sb.WriteString(ref + sep)
+		fields++
+		if match {
+			matched = append(matched, strconv.Itoa(fields))
 		}
 	}
+
+	if opts.StatusBadge {
+		addField(atuinfzf.FieldRef(atuinfzf.FieldStatusBadge), _displaySep, false)
+	}
+	if opts.IgnorePrefixes {
+		addField(atuinfzf.FieldRef(atuinfzf.FieldPrefix), _prefixSep, false)
+	}
+	addField(atuinfzf.FieldRef(atuinfzf.FieldDisplay), _displaySep, true)
+	for _, name := range opts.searchFields() {
+		addField(atuinfzf.FieldRef(_searchFields[name]), _displaySep, true)
+	}
+
+	// Markers are colored, and not matched.
+	markers := []string{atuinfzf.FieldRef(atuinfzf.FieldFavorite), atuinfzf.FieldRef(atuinfzf.FieldCount)}
+	if !opts.StatusBadge && !opts.NoExitStatus {
+		markers = append(markers, atuinfzf.FieldRef(atuinfzf.FieldExitStatus))
+	}
+	markers = append(markers, atuinfzf.FieldRef(atuinfzf.FieldDirContext), atuinfzf.FieldRef(atuinfzf.FieldRank))
+	addField(strings.Join(markers, " "), "", false)
+
+	return sb.String(), strings.Join(matched, ",")
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prashantv/atuin-fzf/atuinfzf"
+)
+
+// _fieldRefRE matches the fzf placeholders returned by atuinfzf.FieldRef.
+var _fieldRefRE = regexp.MustCompile(`\{(\d+)(\.\.)?\}`)
+
+// _ansiRE matches the color escapes that fzf strips with --ansi.
+var _ansiRE = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// matchedText returns the text fzf matches a query against for row, by applying
+// the --with-nth template, then selecting the --nth fields of the displayed text.
+func matchedText(t *testing.T, row atuinfzf.Row, template, nth string) string {
+	t.Helper()
+
+	display := _fieldRefRE.ReplaceAllStringFunc(template, func(ref string) string {
+		f, err := strconv.Atoi(_fieldRefRE.FindStringSubmatch(ref)[1])
+		if err != nil {
+			t.Fatalf("invalid field ref %q: %v", ref, err)
+		}
+		return row[f]
+	})
+	parts := strings.Split(display, _displaySep)
+
+	var matched []string
+	for n := range strings.SplitSeq(nth, ",") {
+		i, err := strconv.Atoi(n)
+		if err != nil || i < 1 || i > len(parts) {
+			t.Fatalf("invalid --nth field %q for %d parts", n, len(parts))
+		}
+		matched = append(matched, parts[i-1])
+	}
+	return _ansiRE.ReplaceAllString(strings.Join(matched, " "), "")
+}
+
+// writeTestRows returns the rows written for entries with the flags in args.
+func writeTestRows(t *testing.T, args []string, entries ...atuinfzf.Entry) (options, []atuinfzf.Row) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", "")
+
+	opts, _, err := parseOptions(args)
+	if err != nil {
+		t.Fatalf("parseOptions(%q): %v", args, err)
+	}
+
+	var buf bytes.Buffer
+	if err := atuinfzf.WriteRows(&buf, slices.Values(entries), opts.Options); err != nil {
+		t.Fatalf("WriteRows: %v", err)
+	}
+
+	var rows []atuinfzf.Row
+	for line := range strings.SplitSeq(strings.TrimSuffix(buf.String(), "\x00"), "\x00") {
+		row, err := atuinfzf.DecodeRow(line)
+		if err != nil {
+			t.Fatalf("DecodeRow: %v", err)
+		}
+		rows = append(rows, row)
+	}
+	return opts, rows
+}
+
+func TestQueryOnlyMatchesCommand(t *testing.T) {
+	e := atuinfzf.Entry{
+		Time:         "2025-01-02 10:00:00",
+		RelativeTime: "5m",
+		Duration:     "5m",
+		Exit:         "38",
+		Directory:    "/srv",
+		Command:      "ls -la",
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "default",
+			want: "ls -la",
+		},
+		{
+			name: "status badge",
+			args: []string{"--status-badge"},
+			want: "ls -la",
+		},
+		{
+			name: "search directory",
+			args: []string{"--search-fields", "directory"},
+			want: "ls -la /srv",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, rows := writeTestRows(t, tt.args, e)
+			if len(rows) != 1 {
+				t.Fatalf("got %d rows, want 1", len(rows))
+			}
+
+			template, nth := withNth(opts)
+			got := strings.TrimSpace(matchedText(t, rows[0], template, nth))
+			if got != tt.want {
+				t.Errorf("matched text %q, want %q", got, tt.want)
+			}
+			for _, query := range []string{"38", "5m"} {
+				if strings.Contains(got, query) {
+					t.Errorf("query %q matches the exit status or time in %q", query, got)
+				}
+			}
+		})
+	}
+}
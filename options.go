@@ -0,0 +1,580 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prashantv/atuin-fzf/atuinfzf"
+	"github.com/prashantv/atuin-fzf/tcolor"
+)
+
+// options are the command-line options for atuin-fzf.
+type options struct {
+	atuinfzf.Options
+
+	FzfBin   string
+	SkBin    string
+	Finder   string
+	Theme    string
+	Scheme   string
+	Tiebreak string
+	Order    string
+	OutputFD int
+	Template string
+
+	FormatPreset string
+
+	AppendSpace    bool
+	EmitDirCommand bool
+	StripPrefix    string
+
+	SimilarTruncate bool
+
+	RememberQuery       bool
+	RememberQueryPerDir bool
+
+	SearchFields string
+	DirAliases   dirAliases
+
+	ShowDelim bool
+
+	Keys        keymap
+	CustomBinds []customBind
+	FzfArgs     []string
+
+	NoDefaultOpts bool
+	NoConfirm     bool
+	TmuxRun       bool
+	ExcludeBuffer bool
+	DirEditor     string
+	MarkdownFmt   string
+	NoAtuinConfig bool
+	SinceBoot     bool
+
+	// Debugging.
+	Profile string
+	Trace   string
+
+	// Internal modes.
+	List           bool
+	Preview        string
+	Zsh            bool
+	ToggleFavorite string
+	EditNote       string
+	Yank           string
+	YankMarkdown   string
+	TmuxWindow     string
+	OpenDir        string
+	StateDir       string
+
+	// selfFlags are the flags set by the user, propagated to subcommands.
+	selfFlags []string
+
+	// setFlags are the names of flags set by the user, so internal modes
+	// run even when their value is empty.
+	setFlags map[string]bool
+}
+
+// _internalFlags are not propagated to subcommands.
+var _internalFlags = map[string]bool{
+	"output-fd": true,
+	"list":      true,
+	"preview":   true,
+	"zsh":       true,
+
+	"toggle-favorite": true,
+	"profile":         true,
+	"trace":           true,
+	"edit-note":       true,
+	"yank":            true,
+	"yank-markdown":   true,
+	"tmux-window":     true,
+	"open-dir":        true,
+	"state-dir":       true,
+}
+
+func parseOptions(args []string) (options, []string, error) {
+	var opts options
+
+	fs := newFlagSet(&opts)
+	if err := fs.Parse(args); err != nil {
+		return opts, nil, err
+	}
+
+	if err := opts.validate(); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return opts, nil, err
+	}
+	if opts.RememberQueryPerDir {
+		opts.RememberQuery = true
+	}
+	opts.NewestFirst = opts.Order == "newest-first"
+	if opts.FormatPreset != "" {
+		opts.Template = _formatPresets[opts.FormatPreset]
+	}
+
+	opts.setFlags = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		opts.setFlags[f.Name] = true
+		if !_internalFlags[f.Name] {
+			opts.selfFlags = append(opts.selfFlags, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+
+	return opts, fs.Args(), nil
+}
+
+// isSet returns whether the user set the flag name, even if to its default value.
+func (o options) isSet(name string) bool {
+	return o.setFlags[name]
+}
+
+// newFlagSet returns the flag set for atuin-fzf, which sets opts.
+func newFlagSet(opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet("atuin-fzf", flag.ContinueOnError)
+	registerAtuinFlags(fs, opts)
+	fs.StringVar(&opts.FzfBin, "fzf-bin", envOr("FZF_BIN", "fzf"), "fzf binary name or path (env: FZF_BIN)")
+	fs.StringVar(&opts.SkBin, "sk-bin", envOr("SK_BIN", "sk"), "skim binary name or path, used with --finder sk (env: SK_BIN)")
+	fs.StringVar(&opts.Finder, "finder", "", "fuzzy finder to use, fzf or sk (default: fzf, or sk if only skim is installed)")
+	fs.StringVar(&opts.Scheme, "scheme", "history", "fzf scoring scheme, one of: "+strings.Join(_fzfSchemes, ", "))
+	fs.StringVar(&opts.Tiebreak, "tiebreak", "", "fzf tiebreak criteria, comma-separated from: "+strings.Join(_fzfTiebreaks, ", "))
+	fs.StringVar(&opts.Order, "order", "newest-last", "list order, newest-last puts the newest entries next to the prompt at the bottom, newest-first at the top")
+	fs.StringVar(&opts.Template, "template", "", "fzf --with-nth template for the displayed fields, e.g. \"{1}  {7} {8}\"")
+	fs.StringVar(&opts.FormatPreset, "format-preset", "", "predefined --template for the displayed fields, one of: "+strings.Join(slices.Sorted(maps.Keys(_formatPresets)), ", "))
+	fs.StringVar(&opts.SearchFields, "search-fields", "", "comma-separated fields to match in addition to the command, from: "+strings.Join(slices.Sorted(maps.Keys(_searchFields)), ", "))
+	opts.DirAliases = make(dirAliases)
+	fs.Var(opts.DirAliases, "dir-alias", "name=dir to only list commands run in dir for queries starting with @name in atuin's search mode, repeatable")
+	fs.BoolVar(&opts.ShowDelim, "show-delim", false, "display the raw delimited rows, for debugging")
+	fs.BoolVar(&opts.DebugRank, "debug-rank", false, "annotate rows with their rank without a query, and run count with --dedup, for debugging")
+	opts.Keys = defaultKeymap()
+	fs.Var(opts.Keys, "keys", "comma-separated action=key pairs to change fzf binds, for actions: "+strings.Join(keyActionNames(), ", "))
+	fs.Var(keyFlag{opts.Keys, "yank"}, "yank-key", "fzf key to copy the command to the clipboard")
+	fs.Var(customBindFlag{&opts.CustomBinds, false}, "custom-bind", "key=command to run a command on the selected row, with {command}, {directory} and {exit} placeholders, repeatable")
+	fs.Var(customBindFlag{&opts.CustomBinds, true}, "custom-bind-silent", "like --custom-bind, but run the command without leaving fzf's UI, repeatable")
+	fs.Var(stringsFlag{&opts.FzfArgs}, "fzf-arg", "extra argument for fzf, e.g. --fzf-arg=--border=rounded, overridden by arguments required to parse rows, repeatable")
+	fs.BoolVar(&opts.NoDefaultOpts, "no-default-opts", false, "ignore FZF_DEFAULT_OPTS and FZF_DEFAULT_OPTS_FILE")
+	fs.StringVar(&opts.MarkdownFmt, "markdown-format", _defaultMarkdownFormat, "format for yanking as Markdown, with {command}, {directory} and \\n for newlines")
+	fs.BoolVar(&opts.NoConfirm, "no-confirm", false, "don't confirm before yanking multi-line or dangerous commands")
+	fs.BoolVar(&opts.TmuxRun, "tmux-run", false, "run the command in the new tmux window, rather than only typing it")
+	fs.StringVar(&opts.DirEditor, "dir-editor", "", "editor command to open a command's directory with, e.g. \"code\" (default: $VISUAL or $EDITOR)")
+	fs.IntVar(&opts.OutputFD, "output-fd", 1, "file descriptor to write the selection to")
+	fs.BoolVar(&opts.EmitDirCommand, "emit-dir-command", false, "write the selected command's directory and the command separated by a null byte, for shell widgets")
+	fs.BoolVar(&opts.AppendSpace, "append-space", false, "append a space to the selection, so arguments can be typed immediately")
+	fs.BoolVar(&opts.RememberQuery, "remember-query", false, "start with the last query if no query is given")
+	fs.BoolVar(&opts.RememberQueryPerDir, "remember-query-per-dir", false, "remember the last query separately for each directory, implies --remember-query")
+	fs.StringVar(&opts.StripPrefix, "strip-prefix", "", "regexp matching a prefix to strip from the selection, such as a line number or comment, e.g. '\\s*[0-9]+\\s+'")
+	registerPreviewFlags(fs, opts)
+	fs.BoolVar(&opts.SimilarTruncate, "similar-truncate", false, "truncate similar commands in the preview to a single line of the preview's width, rather than wrapping them")
+	fs.BoolVar(&opts.NoExitStatus, "no-exit-status", false, "don't show the exit status of failed commands in the list, still showing it in the preview")
+	fs.BoolVar(&opts.StatusBadge, "status-badge", false, "show the exit status as a badge before each command")
+	fs.IntVar(&opts.MetaColumn, "meta-column", 0, "align the markers after commands at this column, unless the command is wider or the terminal too narrow, 0 to disable")
+	fs.BoolVar(&opts.Normalize, "normalize", false, "trim and collapse whitespace in commands for display and matching, still selecting the original command")
+	fs.StringVar(&opts.After, "since", "", "only list commands run after this time, in any format atuin's --after accepts")
+	fs.BoolVar(&opts.SinceBoot, "since-boot", false, "only list commands run since the machine booted")
+	fs.BoolVar(&opts.IgnorePrefixes, "ignore-prefixes", false, "match commands ignoring leading environment variable assignments and sudo or doas")
+	fs.BoolVar(&opts.Dedup, "dedup", false, "list each command once, with the number of times it was run")
+	fs.BoolVar(&opts.CollapseRuns, "collapse-runs", false, "list consecutive runs of the same command once, with the number of times it was run")
+	fs.BoolVar(&opts.CommandsOnly, "commands-only", false, "list the programs run, with Enter listing the commands that ran the selected program")
+	fs.StringVar(&opts.Program, "program", "", "only list commands that run this program")
+	fs.BoolVar(&opts.ByDir, "by-dir", false, "list the directories commands were run in, with Enter listing the commands run in the selected directory")
+	fs.StringVar(&opts.ExcludeCommand, "exclude-command", "", "don't list runs of this command, such as the current command line")
+	fs.BoolVar(&opts.ExcludeBuffer, "exclude-buffer", false, "with --zsh, don't list runs of the command line the history is opened from")
+	fs.BoolVar(&opts.SmartDir, "smart-dir", false, "list commands run in the current directory closest to the prompt, followed by other commands")
+	fs.BoolVar(&opts.GroupByTime, "group-by-time", false, "separate commands run today, yesterday, in the last week and month, and earlier with labeled rows")
+	fs.BoolVar(&opts.GroupBySession, "group-by-session", false, "separate commands run in different atuin sessions with rows labeled with the session")
+	hideFailed, _ := strconv.ParseBool(os.Getenv("ATUIN_FZF_HIDE_FAILED"))
+	fs.BoolVar(&opts.HideFailed, "hide-failed", hideFailed, "hide commands that failed, which can be toggled in fzf (env: ATUIN_FZF_HIDE_FAILED)")
+	fs.BoolVar(&opts.FavoritesOnly, "favorites", false, "only list favorite commands")
+	fs.StringVar(&opts.Profile, "profile", "", "write a CPU profile to this file, for debugging")
+	fs.StringVar(&opts.Trace, "trace", "", "write an execution trace to this file, for debugging")
+	fs.BoolVar(&opts.List, "list", false, "print the fzf rows for the history (internal)")
+	fs.StringVar(&opts.Preview, "preview", "", "render the preview for an fzf row (internal)")
+	fs.StringVar(&opts.ToggleFavorite, "toggle-favorite", "", "toggle whether a command is a favorite (internal)")
+	fs.StringVar(&opts.EditNote, "edit-note", "", "edit the note for a command in $EDITOR (internal)")
+	fs.StringVar(&opts.Yank, "yank", "", "copy a command to the clipboard (internal)")
+	fs.StringVar(&opts.YankMarkdown, "yank-markdown", "", "copy the command for an fzf row to the clipboard as Markdown (internal)")
+	fs.StringVar(&opts.TmuxWindow, "tmux-window", "", "open the command for an fzf row in a new tmux window (internal)")
+	fs.StringVar(&opts.OpenDir, "open-dir", "", "open a directory in the --dir-editor (internal)")
+	fs.StringVar(&opts.StateDir, "state-dir", "", "directory for state that changes while fzf is running (internal)")
+	fs.BoolVar(&opts.Zsh, "zsh", false, "print the zsh integration script")
+	return fs
+}
+
+// registerAtuinFlags registers the flags that control how history is loaded from atuin,
+// shared by all subcommands.
+func registerAtuinFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.AtuinBin, "atuin-bin", envOr("ATUIN_BIN", "atuin"), "atuin binary name or path (env: ATUIN_BIN)")
+	fs.IntVar(&opts.Limit, "limit", 1000, "maximum number of history entries to load")
+	fs.IntVar(&opts.Retries, "atuin-retries", 2, "number of times to retry atuin if its database is locked")
+	fs.IntVar(&opts.MaxCommandSize, "max-command-size", 16<<20, "maximum size in bytes of a history entry, longer entries are skipped")
+	fs.StringVar(&opts.FilterMode, "filter-mode", "", "atuin filter mode for the history, one of: "+strings.Join(_atuinFilterModes, ", ")+" (default: from atuin's config)")
+	fs.StringVar(&opts.SearchMode, "search-mode", "", "atuin search mode for queries, one of: "+strings.Join(_atuinSearchModes, ", ")+" (default: from atuin's config)")
+	fs.BoolVar(&opts.NoAtuinConfig, "no-atuin-config", false, "don't use the filter and search modes from atuin's config")
+	fs.Var(stringsFlag{&opts.AtuinArgs}, "atuin-arg", "extra argument for atuin search, e.g. --atuin-arg=--cwd=/tmp, repeatable")
+}
+
+// registerPreviewFlags registers the flags that control how the preview is rendered,
+// shared by the picker and the inspect subcommand.
+func registerPreviewFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.Theme, "theme", envOr("ATUIN_FZF_THEME", tcolor.DefaultTheme),
+		"color theme, one of: "+strings.Join(tcolor.ThemeNames(), ", ")+" (env: ATUIN_FZF_THEME)")
+	fs.BoolVar(&opts.Timeline, "timeline", false, "show a timeline of when the command was run in the preview")
+	fs.BoolVar(&opts.UsualDir, "usual-dir", false, "show the directory the command is usually run in, in the preview")
+	fs.BoolVar(&opts.AvgDuration, "avg-duration", false, "show the average duration of the command's runs in the preview")
+	fs.BoolVar(&opts.LastSuccess, "last-success", false, "show when and where a failed command last succeeded in the preview")
+	fs.IntVar(&opts.DirSegments, "dir-segments", 0, "elide the middle of directories with more path segments than this, 0 to disable")
+	fs.IntVar(&opts.SimilarWidth, "similar-width", 0, "truncate similar commands in the preview to a single line of this many columns, 0 to wrap them")
+	fs.IntVar(&opts.PreviewMaxBytes, "preview-max-bytes", 16<<10, "truncate commands in the preview longer than this many bytes, 0 to disable")
+	fs.BoolVar(&opts.ASCII, "ascii", !unicodeTerminal(), "use ASCII in place of box-drawing characters and symbols, the default if the locale isn't UTF-8")
+	fs.BoolVar(&opts.NoCurrentDirMarker, "no-current-dir-marker", false, "don't mark commands run in the current directory")
+	fs.BoolVar(&opts.PlainPreview, "plain-preview", false, "only show the command and its details in the preview, without running atuin or the shell")
+	fs.BoolVar(&opts.ResolveSymlinks, "resolve-symlinks", false, "treat directories that are the same through symlinks as one, for the current directory marker, --smart-dir and --by-dir")
+}
+
+// stringsFlag is a repeatable flag that adds each value to values.
+// Values are separated by newlines in String, so the flag can be propagated.
+type stringsFlag struct {
+	values *[]string
+}
+
+func (f stringsFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return strings.Join(*f.values, "\n")
+}
+
+func (f stringsFlag) Set(s string) error {
+	*f.values = append(*f.values, strings.Split(s, "\n")...)
+	return nil
+}
+
+// dirAliases maps the names of directory aliases to directories. As a flag, it's set
+// using name=dir, and is repeatable. Aliases are separated by newlines in String,
+// so the flag can be propagated.
+type dirAliases map[string]string
+
+func (a dirAliases) String() string {
+	var aliases []string
+	for _, name := range slices.Sorted(maps.Keys(a)) {
+		aliases = append(aliases, name+"="+a[name])
+	}
+	return strings.Join(aliases, "\n")
+}
+
+func (a dirAliases) Set(s string) error {
+	for alias := range strings.SplitSeq(s, "\n") {
+		name, dir, ok := strings.Cut(alias, "=")
+		if !ok || name == "" || dir == "" || strings.ContainsAny(name, " \t@") {
+			return fmt.Errorf("expected name=dir with a name without spaces or @, got %q", alias)
+		}
+		a[name] = dir
+	}
+	return nil
+}
+
+// resolveDirAlias only lists commands run in an alias's directory if the query starts
+// with @name, searching atuin for the rest of the query. Otherwise, the query is unchanged,
+// with a hint if the alias isn't known.
+func (o *options) resolveDirAlias() {
+	alias, rest, _ := strings.Cut(o.Query, " ")
+	name, ok := strings.CutPrefix(alias, "@")
+	if !ok || name == "" || len(o.DirAliases) == 0 {
+		return
+	}
+
+	dir, ok := o.DirAliases[name]
+	if !ok {
+		o.Hint = "No directory alias " + alias
+		return
+	}
+	if after, ok := strings.CutPrefix(dir, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, after)
+		}
+	}
+	o.Cwd = dir
+	o.Query = strings.TrimSpace(rest)
+}
+
+// inheritAtuinConfig uses the filter and search modes from atuin's config,
+// unless they're set by flags. If atuin's config can't be read, atuin's defaults are used.
+func (o *options) inheritAtuinConfig() {
+	if o.NoAtuinConfig || (o.FilterMode != "" && o.SearchMode != "") {
+		return
+	}
+
+	cfg, err := atuinfzf.LoadAtuinConfig()
+	if err != nil {
+		return
+	}
+	if o.FilterMode == "" && slices.Contains(_atuinFilterModes, cfg.FilterMode) {
+		o.FilterMode = cfg.FilterMode
+	}
+	if o.SearchMode == "" && slices.Contains(_atuinSearchModes, cfg.SearchMode) {
+		o.SearchMode = cfg.SearchMode
+	}
+}
+
+var (
+	_atuinFilterModes = []string{"global", "host", "session", "directory", "workspace"}
+	_atuinSearchModes = []string{"prefix", "full-text", "fuzzy", "skim"}
+
+	_fzfSchemes   = []string{"default", "path", "history"}
+	_fzfTiebreaks = []string{"length", "chunk", "pathname", "begin", "end", "index"}
+)
+
+func (o options) validateAtuin() error {
+	if o.Limit <= 0 {
+		return fmt.Errorf("invalid --limit %v, must be positive", o.Limit)
+	}
+	if o.Retries < 0 {
+		return fmt.Errorf("invalid --atuin-retries %v, must not be negative", o.Retries)
+	}
+	if o.MaxCommandSize <= 0 {
+		return fmt.Errorf("invalid --max-command-size %v, must be positive", o.MaxCommandSize)
+	}
+	if o.FilterMode != "" && !slices.Contains(_atuinFilterModes, o.FilterMode) {
+		return fmt.Errorf("invalid --filter-mode %q, expected one of: %v", o.FilterMode, strings.Join(_atuinFilterModes, ", "))
+	}
+	if o.SearchMode != "" && !slices.Contains(_atuinSearchModes, o.SearchMode) {
+		return fmt.Errorf("invalid --search-mode %q, expected one of: %v", o.SearchMode, strings.Join(_atuinSearchModes, ", "))
+	}
+	return nil
+}
+
+// validatePreview validates the flags registered by registerPreviewFlags.
+func (o options) validatePreview() error {
+	if o.SimilarWidth < 0 {
+		return fmt.Errorf("invalid --similar-width %v, must not be negative", o.SimilarWidth)
+	}
+	if o.PreviewMaxBytes < 0 {
+		return fmt.Errorf("invalid --preview-max-bytes %v, must not be negative", o.PreviewMaxBytes)
+	}
+	if o.DirSegments == 1 || o.DirSegments < 0 {
+		return fmt.Errorf("invalid --dir-segments %v, must be 0 or at least 2", o.DirSegments)
+	}
+	return nil
+}
+
+func (o options) validate() error {
+	if err := o.validateAtuin(); err != nil {
+		return err
+	}
+	if o.SinceBoot && o.After != "" {
+		return fmt.Errorf("--since and --since-boot can't be used together")
+	}
+	if o.CommandsOnly && o.ByDir {
+		return fmt.Errorf("--commands-only and --by-dir can't be used together")
+	}
+	if o.Zsh && o.EmitDirCommand {
+		// The zsh widget parses the selection, which isn't in the format written for custom widgets.
+		return fmt.Errorf("--emit-dir-command can't be used with --zsh, it's for custom widgets")
+	}
+	if err := o.Keys.validate(); err != nil {
+		return err
+	}
+	if err := o.Keys.validateCustomBinds(o.CustomBinds); err != nil {
+		return err
+	}
+	if _, err := o.stripPrefix(); err != nil {
+		return fmt.Errorf("invalid --strip-prefix: %w", err)
+	}
+	for _, f := range o.searchFields() {
+		if _, ok := _searchFields[f]; !ok {
+			return fmt.Errorf("invalid --search-fields field %q, expected one of: %v", f, strings.Join(slices.Sorted(maps.Keys(_searchFields)), ", "))
+		}
+	}
+	if err := validateTemplate(o.Template); err != nil {
+		return err
+	}
+	if o.FormatPreset != "" {
+		if _, ok := _formatPresets[o.FormatPreset]; !ok {
+			return fmt.Errorf("invalid --format-preset %q, expected one of: %v", o.FormatPreset, strings.Join(slices.Sorted(maps.Keys(_formatPresets)), ", "))
+		}
+		if o.Template != "" {
+			return fmt.Errorf("--template and --format-preset can't be used together")
+		}
+	}
+	if err := o.validatePreview(); err != nil {
+		return err
+	}
+
+	if o.Finder != "" && o.Finder != "fzf" && o.Finder != "sk" {
+		return fmt.Errorf("invalid --finder %q, expected fzf or sk", o.Finder)
+	}
+
+	if !slices.Contains(_fzfSchemes, o.Scheme) {
+		return fmt.Errorf("invalid --scheme %q, expected one of: %v", o.Scheme, strings.Join(_fzfSchemes, ", "))
+	}
+
+	if o.Order != "newest-first" && o.Order != "newest-last" {
+		return fmt.Errorf("invalid --order %q, expected newest-first or newest-last", o.Order)
+	}
+
+	if o.Tiebreak != "" {
+		criteria := strings.Split(o.Tiebreak, ",")
+		for i, c := range criteria {
+			if !slices.Contains(_fzfTiebreaks, c) {
+				return fmt.Errorf("invalid --tiebreak criterion %q, expected one of: %v", c, strings.Join(_fzfTiebreaks, ", "))
+			}
+			if slices.Contains(criteria[:i], c) {
+				return fmt.Errorf("invalid --tiebreak: duplicate criterion %q", c)
+			}
+			if c == "index" && i != len(criteria)-1 {
+				return fmt.Errorf("invalid --tiebreak: index is only allowed at the end")
+			}
+		}
+	}
+
+	return nil
+}
+
+var _templateFieldRE = regexp.MustCompile(`\{(-?\d+)(\.\.)?\}`)
+
+// validateTemplate checks that the fields referenced by a --template exist.
+func validateTemplate(template string) error {
+	for _, m := range _templateFieldRE.FindAllStringSubmatch(template, -1) {
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n < 1 || n > atuinfzf.FieldCommand {
+			return fmt.Errorf("invalid --template field %v, rows have fields 1 to %v", m[0], atuinfzf.FieldCommand)
+		}
+	}
+	return nil
+}
+
+// queryScope returns the scope the last query is remembered in,
+// which is the current directory with --remember-query-per-dir.
+func (o options) queryScope() string {
+	if !o.RememberQueryPerDir {
+		return ""
+	}
+	dir, _ := os.Getwd() // best effort
+	return dir
+}
+
+// stripPrefix returns --strip-prefix anchored to the start of the command, or nil if it's not set.
+func (o options) stripPrefix() (*regexp.Regexp, error) {
+	if o.StripPrefix == "" {
+		return nil, nil
+	}
+	return regexp.Compile(`^(?:` + o.StripPrefix + `)`)
+}
+
+// searchFields returns the fields set by --search-fields.
+func (o options) searchFields() []string {
+	if o.SearchFields == "" {
+		return nil
+	}
+	return strings.Split(o.SearchFields, ",")
+}
+
+// resolveSinceBoot sets the time to list history after to the boot time
+// if --since-boot is set. If the boot time is unavailable, all history is listed.
+func (o *options) resolveSinceBoot() {
+	if !o.SinceBoot {
+		return
+	}
+
+	boot, err := bootTime()
+	if err != nil {
+		log.Printf("ignoring --since-boot: %v", err)
+		return
+	}
+	o.After = boot.Format(time.RFC3339)
+}
+
+// _minMetaWidth is the width needed to display the markers after commands.
+const _minMetaWidth = 20
+
+// resolveMetaColumn disables --meta-column if fzf, or the terminal if fzf isn't
+// running, is too narrow to display the markers after the aligned commands.
+func (o *options) resolveMetaColumn() {
+	columns, _ := strconv.Atoi(cmp.Or(os.Getenv("FZF_COLUMNS"), os.Getenv("COLUMNS")))
+	if columns > 0 && o.MetaColumn+_minMetaWidth > columns {
+		o.MetaColumn = 0
+	}
+}
+
+// selfArgs returns the arguments to propagate to subcommands
+// that fzf invokes on atuin-fzf, such as the preview and reload,
+// so they behave the same as the parent process.
+func (o options) selfArgs() []string {
+	return o.selfFlags
+}
+
+// resolveFinder resolves the binary for --finder, detecting the finder if it's not set.
+func (o *options) resolveFinder() error {
+	switch o.Finder {
+	case "fzf":
+		return resolveBin("fzf", &o.FzfBin)
+	case "sk":
+		return resolveBin("sk", &o.SkBin)
+	}
+
+	fzfErr := resolveBin("fzf", &o.FzfBin)
+	if fzfErr != nil && resolveBin("sk", &o.SkBin) == nil {
+		o.Finder = "sk"
+		return nil
+	}
+	o.Finder = "fzf"
+	return fzfErr
+}
+
+// resolveBin resolves a binary name to a path once at startup, so that
+// misconfiguration is reported upfront rather than from a subprocess.
+func resolveBin(name string, bin *string) error {
+	path, err := exec.LookPath(*bin)
+	if err != nil {
+		return fmt.Errorf("find %v binary: %w", name, err)
+	}
+	*bin = path
+	return nil
+}
+
+// unicodeTerminal reports whether the terminal is expected to render Unicode,
+// based on the locale's character encoding. An unset locale is assumed to support it.
+func unicodeTerminal() bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	for _, key := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if v := os.Getenv(key); v != "" {
+			v = strings.ToLower(v)
+			return strings.Contains(v, "utf-8") || strings.Contains(v, "utf8")
+		}
+	}
+	return true
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// shellQuote quotes s for use as a single argument in a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes and joins args into a POSIX shell command line.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
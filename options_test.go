@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOptionsConflicts(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{
+			args:    []string{"--since", "2025-01-01", "--since-boot"},
+			wantErr: "--since and --since-boot",
+		},
+		{
+			args:    []string{"--commands-only", "--by-dir"},
+			wantErr: "--commands-only and --by-dir",
+		},
+		{
+			args:    []string{"--zsh", "--emit-dir-command"},
+			wantErr: "--emit-dir-command can't be used with --zsh",
+		},
+		{
+			args: []string{"--emit-dir-command"},
+		},
+		{
+			args:    []string{"--keys", "yank=enter"},
+			wantErr: "yank can't be bound to enter",
+		},
+		{
+			args:    []string{"--keys", "reload=change"},
+			wantErr: "reload can't be bound to change",
+		},
+		{
+			args:    []string{"--keys", "toggle-preview=load"},
+			wantErr: "toggle-preview can't be bound to load",
+		},
+		{
+			args:    []string{"--custom-bind", "start=echo {command}"},
+			wantErr: "start is already bound",
+		},
+		{
+			args:    []string{"--custom-bind-silent", "enter=echo {command}"},
+			wantErr: "enter is already bound",
+		},
+		{
+			args:    []string{"--custom-bind", "ctrl-/=echo {command}"},
+			wantErr: "ctrl-/ is already bound to toggle-preview",
+		},
+		{
+			args: []string{"--keys", "toggle-preview=f2", "--custom-bind", "ctrl-/=echo {command}"},
+		},
+		{
+			args: []string{"--zsh"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			_, _, err := parseOptions(tt.args)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parseOptions(%q): %v", tt.args, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseOptions(%q) error %v, want %q", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prashantv/atuin-fzf/atuinfzf"
+)
+
+// _fakeAtuin prints two history entries in the format requested by atuinfzf.Search.
+const _fakeAtuin = `#!/bin/sh
+printf '2025-01-02 10:00:00\t:::\t5m\t:::\t1s\t:::\t0\t:::\t/srv\t:::\tls -la\0'
+printf '2025-01-02 11:00:00\t:::\t4m\t:::\t2s\t:::\t1\t:::\t/tmp\t:::\tgit push\0'
+`
+
+// _fakeFzfSelect selects the first row, printing its command like --accept-nth.
+var _fakeFzfSelect = fmt.Sprintf(`#!/bin/sh
+tr '\0' '\n' | head -n 1 | awk -F '\t:::\t' '{ s = $%d; for (i = %d; i <= NF; i++) s = s FS $i; print s }'
+`, atuinfzf.FieldCommand, atuinfzf.FieldCommand+1)
+
+// fakeBins writes each script to an executable named by its key in a temp dir,
+// which is put first on PATH, so they're used in place of the real binaries.
+func fakeBins(t *testing.T, scripts map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatalf("write fake %v: %v", name, err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+// isolateEnv stops the user's home directory, atuin config and fzf options
+// from affecting a test.
+func isolateEnv(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("ATUIN_CONFIG_DIR", t.TempDir())
+	t.Setenv("FZF_DEFAULT_OPTS", "")
+}
+
+// runFake parses args and runs atuin-fzf like main, using the fake binaries
+// set up by fakeBins, and returns what was written to stdout.
+func runFake(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	isolateEnv(t)
+
+	opts, rest, err := parseOptions(args)
+	if err != nil {
+		t.Fatalf("parseOptions(%q): %v", args, err)
+	}
+	if err := resolveBin("atuin", &opts.AtuinBin); err != nil {
+		t.Fatal(err)
+	}
+	opts.inheritAtuinConfig()
+	if err := opts.resolveFinder(); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	origStdout := os.Stdout
+	os.Stdout = stdout
+	defer func() { os.Stdout = origStdout }()
+
+	runErr := run(opts, strings.Join(rest, " "))
+
+	out, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), runErr
+}
+
+func TestRunFakeBins(t *testing.T) {
+	tests := []struct {
+		name    string
+		fzf     string
+		args    []string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "select",
+			fzf:  _fakeFzfSelect,
+			want: "ls -la\n",
+		},
+		{
+			name: "append space",
+			fzf:  _fakeFzfSelect,
+			args: []string{"--append-space"},
+			want: "ls -la \n",
+		},
+		{
+			name:    "no selection",
+			fzf:     "#!/bin/sh\ncat >/dev/null\nexit 130\n",
+			wantErr: errNoSelection,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeBins(t, map[string]string{
+				"atuin": _fakeAtuin,
+				"fzf":   tt.fzf,
+			})
+
+			got, err := runFake(t, tt.args...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("run error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("output %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunFakeBinsQuery(t *testing.T) {
+	dir := fakeBins(t, map[string]string{
+		"atuin": _fakeAtuin,
+		// Record the arguments, one per line, before selecting.
+		"fzf": "#!/bin/sh\nprintf '%s\\n' \"$@\" > \"$(dirname \"$0\")/fzf-args\"\n" + strings.TrimPrefix(_fakeFzfSelect, "#!/bin/sh\n"),
+	})
+
+	if _, err := runFake(t, "git", "push"); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	args, err := os.ReadFile(filepath.Join(dir, "fzf-args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(args), "\n--query\ngit push\n") {
+		t.Errorf("fzf args don't set the query to %q:\n%s", "git push", args)
+	}
+}
+
+func TestProfileWithoutSelection(t *testing.T) {
+	fakeBins(t, map[string]string{
+		"atuin": _fakeAtuin,
+		"fzf":   "#!/bin/sh\ncat >/dev/null\nexit 130\n",
+	})
+	isolateEnv(t)
+
+	dir := t.TempDir()
+	profile, traceFile := filepath.Join(dir, "cpu.pprof"), filepath.Join(dir, "trace.out")
+	if got := pickerMain([]string{"--profile", profile, "--trace", traceFile}); got != _exitNoSelection {
+		t.Errorf("exit code %v, want %v", got, _exitNoSelection)
+	}
+
+	// The profile and trace are only complete once stopped.
+	for _, path := range []string{profile, traceFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 {
+			t.Errorf("%v is empty, it wasn't stopped before exiting", filepath.Base(path))
+		}
+	}
+}
+
+func TestAtuinToFzfStopsOnClose(t *testing.T) {
+	dir := fakeBins(t, map[string]string{
+		// The session's history is read first and fully, so it's empty, while the
+		// global history records its PID, then prints entries until killed.
+		"atuin": `#!/bin/sh
+case "$*" in *session*) exit 0 ;; esac
+echo $$ > "$(dirname "$0")/atuin-pid"
+while :; do
+	printf '2025-01-02 10:00:00\t:::\t5m\t:::\t1s\t:::\t0\t:::\t/srv\t:::\tls -la\0'
+done
+`,
+	})
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_DATA_HOME", "")
+
+	opts, _, err := parseOptions([]string{"--state-dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolveBin("atuin", &opts.AtuinBin); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results, err := atuinfzf.History(ctx, opts.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fzfInput, waitRows, err := atuinToFzf(ctx, opts, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read some rows, then stop reading mid-stream like fzf exiting.
+	if _, err := fzfInput.Read(make([]byte, 4096)); err != nil {
+		t.Fatalf("read rows: %v", err)
+	}
+	fzfInput.Close()
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		waitRows()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitRows didn't return after the reader was closed")
+	}
+
+	pidText, err := os.ReadFile(filepath.Join(dir, "atuin-pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidText)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The child is waited for, so once it exits, its PID no longer exists.
+	if err := syscall.Kill(pid, 0); !errors.Is(err, syscall.ESRCH) {
+		t.Errorf("atuin (pid %v) still running after waitRows: %v", pid, err)
+	}
+}
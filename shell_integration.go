@@ -12,7 +12,9 @@ redraw-prompt() {
 
 atuin-fzf-history() {
     local result
-    result=$(%v "$BUFFER")
+    # The selection is written to fd 3, keeping stdout and stderr for the UI.
+    # The cursor is placed at the end of the selection, after any --append-space.
+    result=$(%v --output-fd 3 -- "$BUFFER" 3>&1 1>&2)
     if [[ -z "$result" ]]; then
         zle redisplay
         return
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/prashantv/atuin-fzf/atuinfzf"
+)
+
+// sk runs skim in place of fzf. skim doesn't support fzf's templates or actions
+// that change the UI, so only the command is displayed, and binds are limited to
+// those that run atuin-fzf. skim prints the whole row, so the command is decoded here.
+func sk(opts options, input io.Reader, query string, output io.Writer) error {
+	skArgs := []string{
+		"--tac",
+		"--prompt", "> ",
+		"--preview-window", "right:40%:wrap",
+		"--height", "80%",
+		"--query", query,
+		// skim can't run a command on accept, so the chdir key is reported by --expect.
+		"--expect", opts.Keys["chdir"],
+	}
+	if opts.Order == "newest-first" {
+		skArgs = append(skArgs, "--reverse")
+	}
+	if opts.RememberQuery {
+		skArgs = append(skArgs, "--print-query")
+	}
+
+	hints := []string{"[Enter] to select", opts.Keys.hint("chdir")}
+	previewCmd := inlinePreviewCmd()
+	if selfExe, err := selfExecutable(); err != nil {
+		log.Printf("using basic preview: %v", err)
+	} else {
+		selfCmd := shellJoin(append([]string{selfExe}, opts.selfArgs()...))
+		previewCmd = selfCmd + " --preview " + rowDataRef()
+		if canYank() {
+			skArgs = append(skArgs,
+				"--bind", opts.Keys["yank"]+":execute("+selfCmd+" --yank "+atuinfzf.FieldRef(atuinfzf.FieldCommand)+")+abort",
+				"--bind", opts.Keys["yank-markdown"]+":execute("+selfCmd+" --yank-markdown "+rowDataRef()+")+abort",
+			)
+			hints = append(hints, opts.Keys.hint("yank"), opts.Keys.hint("yank-markdown"))
+		}
+		skArgs = append(skArgs, "--bind", opts.Keys["note"]+":execute("+selfCmd+" --edit-note "+atuinfzf.FieldRef(atuinfzf.FieldCommand)+")")
+		hints = append(hints, opts.Keys.hint("note"))
+	}
+
+	skArgs = append(skArgs, "--bind", opts.Keys["toggle-preview"]+":toggle-preview")
+	hints = append(hints, opts.Keys.hint("toggle-preview"))
+
+	// Essential flags, which must match the rows and subcommands.
+	skArgs = append(skArgs,
+		"--header", strings.Join(hints, ", ")+".",
+		"--read0",
+		"--ansi",
+		"--delimiter", atuinfzf.Delim,
+		"--with-nth", fmt.Sprint(atuinfzf.FieldDisplay),
+		"--nth", fmt.Sprint(atuinfzf.FieldDisplay),
+		"--preview", previewCmd,
+	)
+
+	skCmd := exec.Command(opts.SkBin, skArgs...)
+	if opts.NoDefaultOpts {
+		skCmd.Env = append(os.Environ(), "SKIM_DEFAULT_OPTIONS=")
+	}
+	var out bytes.Buffer
+	skCmd.Stdin = input
+	skCmd.Stderr = os.Stderr
+	skCmd.Stdout = &out
+
+	if err := finderError("sk", skCmd.Run()); err != nil {
+		return err
+	}
+
+	// The output is the query with --print-query, the key from --expect, and the selected row.
+	selection := out.String()
+	if opts.RememberQuery {
+		query, rest, _ := strings.Cut(selection, "\n")
+		if _, err := io.WriteString(output, query+"\n"); err != nil {
+			return err
+		}
+		selection = rest
+	}
+	key, row, _ := strings.Cut(selection, "\n")
+	r, err := atuinfzf.DecodeRow(strings.TrimSuffix(row, "\n"))
+	if err != nil {
+		return fmt.Errorf("parse sk selection: %w", err)
+	}
+	if r.IsSeparator() {
+		// skim can't ignore the selection, so a separator row selects nothing.
+		return errNoSelection
+	}
+
+	// Match the output of fzf's accept and chdir binds.
+	text := r[atuinfzf.FieldCommand] + "\n"
+	switch {
+	case key == opts.Keys["chdir"]:
+		text = _chdirPrefix + r[atuinfzf.FieldDirectory] + "\t" + r[atuinfzf.FieldCommand]
+	case opts.EmitDirCommand:
+		text = r[atuinfzf.FieldDirectory] + "\x00" + r[atuinfzf.FieldCommand]
+	}
+	_, err = io.WriteString(output, text)
+	return err
+}
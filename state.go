@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// State that changes while fzf is running is tracked by files in --state-dir,
+// so it's shared by the reloads and binds run by fzf.
+const (
+	// _hideFailedState exists if failed commands are hidden.
+	_hideFailedState = "hide-failed"
+
+	// _limitedState exists if the loaded history may have been capped by --limit.
+	_limitedState = "limited"
+
+	// _programState holds the program selected from the list of programs with --commands-only.
+	_programState = "program"
+
+	// _dirState holds the directory selected from the list of directories with --by-dir.
+	_dirState = "dir"
+)
+
+// newStateDir creates the directory for --state-dir, with the initial state from opts.
+func newStateDir(opts options) (string, error) {
+	dir, err := os.MkdirTemp("", "atuin-fzf-")
+	if err != nil {
+		return "", err
+	}
+
+	opts.StateDir = dir
+	if err := opts.setState(_hideFailedState, opts.HideFailed); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+func (o options) statePath(name string) string {
+	return filepath.Join(o.StateDir, name)
+}
+
+// hasState reports whether the state name is set.
+func (o options) hasState(name string) bool {
+	_, err := os.Stat(o.statePath(name))
+	return err == nil
+}
+
+// setState sets or clears the state name. It's a no-op without a --state-dir.
+func (o options) setState(name string, set bool) error {
+	if o.StateDir == "" {
+		return nil
+	}
+	if set {
+		return os.WriteFile(o.statePath(name), nil, 0o600)
+	}
+	if err := os.Remove(o.statePath(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// resolveHideFailed sets HideFailed from the state dir, where it's toggled in fzf.
+func (o *options) resolveHideFailed() {
+	if o.StateDir != "" {
+		o.HideFailed = o.hasState(_hideFailedState)
+	}
+}
+
+// resolveProgram lists the runs of the program selected with --commands-only,
+// once it's been selected in fzf.
+func (o *options) resolveProgram() {
+	if o.StateDir == "" {
+		return
+	}
+	if program, err := os.ReadFile(o.statePath(_programState)); err == nil && len(program) > 0 {
+		o.Program = string(program)
+		o.CommandsOnly = false
+	}
+}
+
+// resolveDir lists the commands run in the directory selected with --by-dir,
+// once it's been selected in fzf.
+func (o *options) resolveDir() {
+	if o.StateDir == "" {
+		return
+	}
+	if dir, err := os.ReadFile(o.statePath(_dirState)); err == nil && len(dir) > 0 {
+		o.Dir = string(dir)
+		o.ByDir = false
+	}
+}
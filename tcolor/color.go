@@ -1,20 +1,155 @@
 package tcolor
 
-import "fmt"
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
 
+// Color is an index into the terminal's 256-color palette.
+//
+// Indexes 0-7 are the standard colors, 8-15 are their bright variants,
+// 16-231 form a 6x6x6 color cube, and 232-255 are a grayscale ramp.
 type Color int
 
+// Standard and bright colors. Their exact appearance depends on the terminal's theme.
 const (
-	Red   Color = 1
-	Green Color = 2
-	Cyan  Color = 6
-	Gray  Color = 8
+	Black   Color = 0
+	Red     Color = 1
+	Green   Color = 2
+	Yellow  Color = 3
+	Blue    Color = 4
+	Magenta Color = 5
+	Cyan    Color = 6
+	White   Color = 7
+
+	BrightBlack   Color = 8
+	BrightRed     Color = 9
+	BrightGreen   Color = 10
+	BrightYellow  Color = 11
+	BrightBlue    Color = 12
+	BrightMagenta Color = 13
+	BrightCyan    Color = 14
+	BrightWhite   Color = 15
+
+	Gray = BrightBlack
 )
 
+// Color256 returns the color at index n (0-255) of the 256-color palette.
+// Using a uint8 ensures the index is always within the palette.
+func Color256(n uint8) Color {
+	return Color(n)
+}
+
 func (c Color) Foreground(s string) string {
-	return fmt.Sprintf("\033[38;5;%dm%s\033[0m", c, s)
+	return Style{}.Foreground(c).Render(s)
 }
 
 func Bold(s string) string {
-	return fmt.Sprintf("\033[1m%s\033[0m", s)
+	return Style{}.Bold().Render(s)
+}
+
+// _enabled is whether styles are rendered, see SetEnabled.
+var _enabled = true
+
+// SetEnabled sets whether styles are rendered. If disabled,
+// text is returned as-is, without escape sequences.
+func SetEnabled(enabled bool) {
+	_enabled = enabled
+}
+
+// Style combines text attributes, which are rendered using a single escape
+// sequence and reset. Nesting Bold and Foreground instead resets all attributes
+// at the end of the inner text.
+type Style struct {
+	bold  bool
+	fg    Color
+	hasFg bool
+}
+
+// Bold returns the style with bold text.
+func (s Style) Bold() Style {
+	s.bold = true
+	return s
+}
+
+// Foreground returns the style with the foreground color c.
+func (s Style) Foreground(c Color) Style {
+	s.fg, s.hasFg = c, true
+	return s
+}
+
+// Render returns str with the style applied.
+func (s Style) Render(str string) string {
+	var codes []string
+	if s.bold {
+		codes = append(codes, "1")
+	}
+	if s.hasFg {
+		codes = append(codes, fmt.Sprintf("38;5;%d", s.fg))
+	}
+	if len(codes) == 0 || !_enabled {
+		return str
+	}
+	return "\033[" + strings.Join(codes, ";") + "m" + str + "\033[0m"
+}
+
+// VisibleWidth returns the number of columns s takes when displayed,
+// ignoring escape sequences, such as those added by Render.
+// East Asian wide and fullwidth runes take two columns, and others take one.
+func VisibleWidth(s string) int {
+	var width int
+	for i := 0; i < len(s); {
+		if s[i] == '\033' && i+1 < len(s) && s[i+1] == '[' {
+			// Skip the control sequence, up to and including its final byte.
+			i += 2
+			for i < len(s) && (s[i] < 0x40 || s[i] > 0x7e) {
+				i++
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		width += runeWidth(r)
+		i += size
+	}
+	return width
+}
+
+// _wideRanges are the inclusive ranges of East Asian wide and fullwidth runes,
+// sorted by their start. It covers the common CJK, Hangul and emoji blocks,
+// rather than the full Unicode table.
+var _wideRanges = [][2]rune{
+	{0x1100, 0x115F},   // Hangul Jamo
+	{0x231A, 0x231B},   // watch, hourglass
+	{0x2329, 0x232A},   // angle brackets
+	{0x2E80, 0x303E},   // CJK radicals, symbols and punctuation
+	{0x3041, 0x33FF},   // Hiragana, Katakana, Bopomofo and CJK compatibility
+	{0x3400, 0x4DBF},   // CJK unified ideographs extension A
+	{0x4E00, 0x9FFF},   // CJK unified ideographs
+	{0xA000, 0xA4CF},   // Yi
+	{0xA960, 0xA97F},   // Hangul Jamo extended A
+	{0xAC00, 0xD7A3},   // Hangul syllables
+	{0xF900, 0xFAFF},   // CJK compatibility ideographs
+	{0xFE10, 0xFE19},   // vertical forms
+	{0xFE30, 0xFE6F},   // CJK compatibility and small forms
+	{0xFF00, 0xFF60},   // fullwidth forms
+	{0xFFE0, 0xFFE6},   // fullwidth signs
+	{0x1F300, 0x1F64F}, // pictographs and emoticons
+	{0x1F900, 0x1F9FF}, // supplemental pictographs
+	{0x20000, 0x2FFFD}, // CJK extensions B to F
+	{0x30000, 0x3FFFD}, // CJK extension G onwards
+}
+
+// runeWidth returns the number of columns r takes when displayed.
+func runeWidth(r rune) int {
+	i, found := slices.BinarySearchFunc(_wideRanges, r, func(rng [2]rune, r rune) int {
+		return cmp.Compare(rng[0], r)
+	})
+	if found || (i > 0 && r <= _wideRanges[i-1][1]) {
+		return 2
+	}
+	return 1
 }
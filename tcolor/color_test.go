@@ -0,0 +1,81 @@
+package tcolor
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{
+			name: "plain style",
+			got:  Style{}.Render("text"),
+			want: "text",
+		},
+		{
+			name: "bold",
+			got:  Bold("text"),
+			want: "\033[1mtext\033[0m",
+		},
+		{
+			name: "foreground",
+			got:  Red.Foreground("text"),
+			want: "\033[38;5;1mtext\033[0m",
+		},
+		{
+			name: "256 color foreground",
+			got:  Color256(242).Foreground("text"),
+			want: "\033[38;5;242mtext\033[0m",
+		},
+		{
+			name: "bold and foreground",
+			got:  Style{}.Bold().Foreground(Red).Render("text"),
+			want: "\033[1;38;5;1mtext\033[0m",
+		},
+		{
+			name: "empty text",
+			got:  Bold(""),
+			want: "\033[1m\033[0m",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderDisabled(t *testing.T) {
+	SetEnabled(false)
+	defer SetEnabled(true)
+
+	if got := (Style{}).Bold().Foreground(Red).Render("text"); got != "text" {
+		t.Errorf("got %q with styles disabled, want %q", got, "text")
+	}
+}
+
+func TestVisibleWidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{s: "", want: 0},
+		{s: "text", want: 4},
+		{s: Style{}.Bold().Foreground(Red).Render("text"), want: 4},
+		{s: "héllo", want: 5},
+		{s: "你好", want: 4},
+		{s: "echo 你好，世界", want: 5 + 10},
+		{s: "ｆｕｌｌ", want: 8},
+		{s: "한국어", want: 6},
+		{s: "✓ ok", want: 4},
+		{s: Style{}.Foreground(Red).Render("文档") + "/x", want: 6},
+	}
+	for _, tt := range tests {
+		if got := VisibleWidth(tt.s); got != tt.want {
+			t.Errorf("VisibleWidth(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package tcolor
+
+import (
+	"io"
+	"strings"
+)
+
+// Table renders rows of cells in aligned columns. Cells are measured using
+// VisibleWidth, so colored cells are aligned. Cells must be a single line.
+type Table struct {
+	rows [][]string
+}
+
+// Add adds a row of cells.
+func (t *Table) Add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// WriteTo writes each row on its own line, with cells separated by a space and
+// padded to the widest cell in their column. The last cell in a row isn't padded,
+// and trailing cells without visible text are omitted.
+func (t *Table) WriteTo(w io.Writer) (int64, error) {
+	var widths []int
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], VisibleWidth(cell))
+		}
+	}
+
+	var sb strings.Builder
+	for _, row := range t.rows {
+		for len(row) > 0 && VisibleWidth(row[len(row)-1]) == 0 {
+			row = row[:len(row)-1]
+		}
+		for i, cell := range row {
+			sb.WriteString(cell)
+			if i < len(row)-1 {
+				sb.WriteString(strings.Repeat(" ", widths[i]-VisibleWidth(cell)+1))
+			}
+		}
+		sb.WriteString("\n")
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
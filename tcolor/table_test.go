@@ -0,0 +1,50 @@
+package tcolor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	tests := []struct {
+		name string
+		rows [][]string
+		want string
+	}{
+		{
+			name: "aligned",
+			rows: [][]string{{"When:", "5m ago"}, {"Directory:", "/srv"}},
+			want: "When:      5m ago\nDirectory: /srv\n",
+		},
+		{
+			name: "wide runes",
+			rows: [][]string{{"~/文档", "exit 1"}, {"/srv/app", "exit 2"}},
+			want: "~/文档   exit 1\n/srv/app exit 2\n",
+		},
+		{
+			name: "styled cells",
+			rows: [][]string{{Style{}.Bold().Render("a"), "x"}, {"bcd", "y"}},
+			want: Style{}.Bold().Render("a") + "   x\nbcd y\n",
+		},
+		{
+			name: "trailing empty cells",
+			rows: [][]string{{"a", "b", ""}, {"cc", "d", "e"}},
+			want: "a  b\ncc d e\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var table Table
+			for _, row := range tt.rows {
+				table.Add(row...)
+			}
+			var sb strings.Builder
+			if _, err := table.WriteTo(&sb); err != nil {
+				t.Fatal(err)
+			}
+			if got := sb.String(); got != tt.want {
+				t.Errorf("table:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package tcolor
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Theme maps semantic roles to concrete colors.
+type Theme struct {
+	Success   Color
+	Failure   Color
+	Muted     Color
+	Highlight Color
+	Warning   Color
+
+	// StatusGlyphs marks success and failure with glyphs as well as colors,
+	// so they can be told apart without relying on hue.
+	StatusGlyphs bool
+}
+
+// Themes are the built-in theme presets, keyed by name.
+var Themes = map[string]Theme{
+	"dark": {
+		Success:   Green,
+		Failure:   Red,
+		Muted:     Color256(242),
+		Highlight: Cyan,
+		Warning:   Yellow,
+	},
+	"light": {
+		Success:   Color256(28),
+		Failure:   Color256(124),
+		Muted:     Color256(243),
+		Highlight: Color256(25),
+		Warning:   Color256(130),
+	},
+	// colorblind avoids distinguishing success and failure by red and green,
+	// which are hard to tell apart with the most common color vision deficiencies.
+	"colorblind": {
+		Success:      Color256(33),  // blue
+		Failure:      Color256(208), // orange
+		Muted:        Color256(242),
+		Highlight:    Color256(141), // purple, distinct from success.
+		Warning:      Yellow,
+		StatusGlyphs: true,
+	},
+	"solarized": {
+		Success:   Color256(64),
+		Failure:   Color256(160),
+		Muted:     Color256(245),
+		Highlight: Color256(37),
+		Warning:   Color256(136),
+	},
+}
+
+// DefaultTheme is the name of the theme used unless SetTheme is called.
+const DefaultTheme = "dark"
+
+var _current = Themes[DefaultTheme]
+
+// SetTheme sets the current theme by name.
+func SetTheme(name string) error {
+	t, ok := Themes[name]
+	if !ok {
+		return fmt.Errorf("unknown theme %q, expected one of: %v", name, strings.Join(ThemeNames(), ", "))
+	}
+	_current = t
+	return nil
+}
+
+// Current returns the current theme.
+func Current() Theme {
+	return _current
+}
+
+// ThemeNames returns the sorted names of the built-in themes.
+func ThemeNames() []string {
+	return slices.Sorted(maps.Keys(Themes))
+}
+
+// Success colors s using the current theme's success color.
+func Success(s string) string {
+	return _current.Success.Foreground(s)
+}
+
+// Failure colors s using the current theme's failure color.
+func Failure(s string) string {
+	return _current.Failure.Foreground(s)
+}
+
+// Muted colors s using the current theme's muted color.
+func Muted(s string) string {
+	return _current.Muted.Foreground(s)
+}
+
+// Highlight colors s using the current theme's highlight color.
+func Highlight(s string) string {
+	return _current.Highlight.Foreground(s)
+}
+
+// Warning colors s using the current theme's warning color.
+func Warning(s string) string {
+	return _current.Warning.Foreground(s)
+}
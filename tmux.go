@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/prashantv/atuin-fzf/atuinfzf"
+)
+
+// inTmux reports whether atuin-fzf is running inside tmux.
+func inTmux() bool {
+	return os.Getenv("TMUX") != ""
+}
+
+// tmuxWindow opens a new tmux window in the entry's directory, with its command
+// typed at the prompt, or run if run is set.
+func tmuxWindow(e atuinfzf.Entry, run, noConfirm bool) error {
+	if !inTmux() {
+		return errors.New("not running inside tmux")
+	}
+	if reason := confirmReason(e.Command); run && reason != "" && !noConfirm {
+		if !confirm(fmt.Sprintf("Run %v in a new tmux window?", reason)) {
+			return nil
+		}
+	}
+
+	out, err := exec.Command("tmux", "new-window", "-P", "-F", "#{pane_id}", "-c", e.Directory).Output()
+	if err != nil {
+		return fmt.Errorf("tmux new-window: %w", err)
+	}
+
+	pane := strings.TrimSpace(string(out))
+	if err := exec.Command("tmux", "send-keys", "-t", pane, "-l", "--", e.Command).Run(); err != nil {
+		return fmt.Errorf("tmux send-keys: %w", err)
+	}
+	if run {
+		if err := exec.Command("tmux", "send-keys", "-t", pane, "Enter").Run(); err != nil {
+			return fmt.Errorf("tmux send-keys: %w", err)
+		}
+	}
+	return nil
+}
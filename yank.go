@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/prashantv/atuin-fzf/atuinfzf"
+)
+
+// _dangerousPatterns match commands that are destructive if run by mistake.
+var _dangerousPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\brm\s+(-\S*[rf]|--recursive|--force)`),
+	regexp.MustCompile(`\b(dd|mkfs(\.\w+)?|shred|wipefs)\s`),
+	regexp.MustCompile(`\bgit\s+(push\s.*(-f\b|--force)|reset\s+--hard|clean\s+-\S*f)`),
+	regexp.MustCompile(`>\s*/dev/(sd|nvme|disk)`),
+	regexp.MustCompile(`\b(DROP|TRUNCATE)\s+(TABLE|DATABASE)\b`),
+}
+
+// confirmReason returns why command should be confirmed before it's used,
+// or an empty string if it's safe.
+func confirmReason(command string) string {
+	if strings.Contains(strings.TrimSpace(command), "\n") {
+		return "multi-line command"
+	}
+	for _, re := range _dangerousPatterns {
+		if re.MatchString(command) {
+			return "potentially dangerous command"
+		}
+	}
+	return ""
+}
+
+// confirm prompts on the terminal whether to continue, defaulting to no.
+func confirm(prompt string) bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "%v [y/N] ", prompt)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// pause shows msg on the terminal until Enter is pressed, so it's seen
+// before fzf redraws the screen.
+func pause(msg string) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "%v Press Enter to continue.", msg)
+	bufio.NewReader(tty).ReadString('\n')
+}
+
+// _clipboardCmds are commands that copy stdin to the clipboard, in order of preference.
+var _clipboardCmds = [][]string{
+	{"pbcopy"},
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+	{"clip.exe"},
+}
+
+// clipboardCmd returns the first available command to copy to the clipboard,
+// or nil if none are available.
+func clipboardCmd() []string {
+	for _, cmd := range _clipboardCmds {
+		switch cmd[0] {
+		case "wl-copy":
+			if os.Getenv("WAYLAND_DISPLAY") == "" {
+				continue
+			}
+		case "xclip", "xsel":
+			if os.Getenv("DISPLAY") == "" {
+				continue
+			}
+		}
+		if _, err := exec.LookPath(cmd[0]); err == nil {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// canYank returns whether there's a clipboard command, or a terminal
+// to copy to the clipboard using an OSC 52 escape sequence.
+func canYank() bool {
+	if clipboardCmd() != nil {
+		return true
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	tty.Close()
+	return true
+}
+
+// _defaultMarkdownFormat is the default format for yanking a command as Markdown.
+const _defaultMarkdownFormat = "```sh\n{command}\n```"
+
+// markdownText formats the entry e using format, replacing {command} and {directory},
+// and \n escapes with newlines.
+func markdownText(format string, e atuinfzf.Entry) string {
+	return strings.NewReplacer(
+		`\n`, "\n",
+		"{command}", e.Command,
+		"{directory}", e.Directory,
+	).Replace(format)
+}
+
+// yank copies text for command to the clipboard, confirming multi-line and
+// dangerous commands unless noConfirm is set.
+func yank(command, text string, noConfirm bool) error {
+	if reason := confirmReason(command); reason != "" && !noConfirm {
+		if !confirm(fmt.Sprintf("%v\n\nCopy %v?", command, reason)) {
+			return nil
+		}
+	}
+
+	clipCmd := clipboardCmd()
+	if clipCmd == nil {
+		return copyOSC52(text)
+	}
+
+	cmd := exec.Command(clipCmd[0], clipCmd[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("copy to clipboard: %w", err)
+	}
+	return nil
+}
+
+// copyOSC52 copies s to the clipboard using an OSC 52 escape sequence,
+// which is supported by many terminals, including over SSH.
+func copyOSC52(s string) error {
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("copy to clipboard: no clipboard command or terminal: %w", err)
+	}
+	defer tty.Close()
+
+	_, err = fmt.Fprintf(tty, "\x1b]52;c;%v\a", base64.StdEncoding.EncodeToString([]byte(s)))
+	return err
+}